@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"lg/internal"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+)
+
+// fakeleaf serves the fake Leaf on its own, e.g. for a load generator on another machine.
+func main() {
+	listen := flag.String("listen", ":50050", "address to serve the Leaf gRPC service on")
+	config := flag.String("config", "", "fake Leaf config file, see the fake_leaf section in the README (default 5ms per call)")
+	debug := flag.Bool("debug", false, "log every created function")
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+
+	var fakeConfig *internal.FakeLeafConfig
+	if *config != "" {
+		var err error
+		fakeConfig, err = internal.LoadFakeLeafConfig(*config)
+		if err != nil {
+			log.Fatalf("Failed to load fake Leaf config: %v", err)
+		}
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listen, err)
+	}
+	if err := internal.NewFakeLeaf(fakeConfig, logger).Serve(lis); err != nil {
+		log.Fatalf("Fake Leaf failed: %v", err)
+	}
+}
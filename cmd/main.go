@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"io"
 	"lg/internal"
+	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/goforj/godump"
 )
@@ -17,22 +26,165 @@ func main() {
 	}()
 	config := flag.String("config", "workload_config.yaml", "config file")
 	out := flag.String("out", "results.csv", "output collector file name")
+	format := flag.String("format", "", "output format: csv, jsonl, parquet or sqlite (default from the --out extension, else csv)")
 	logLevel := flag.String("log-level", "info", "log level")
+	tui := flag.Bool("tui", false, "show a live dashboard instead of log lines, logs go to <out>.log")
+	summaryFiles := flag.Bool("summary-files", false, "also write the end-of-run summary to <out>.summary.json and <out>.summary.md, the instances to <out>.instances.json and the generator accuracy to <out>.accuracy.json")
+	hdrLog := flag.String("hdr-log", "", "write latency histograms to this HdrHistogram interval log")
+	hdrInterval := flag.Duration("hdr-interval", 10*time.Second, "length of each window in the HdrHistogram log")
+	flushInterval := flag.Duration("flush-interval", time.Second, "how often results are flushed to --out")
+	collectorBuffer := flag.Int("collector-buffer", 65536, "results that can wait for the writer before calls block")
+	worker := flag.String("worker", "", "run as a worker that waits for a coordinator on this address, e.g. :7071")
+	workers := flag.String("workers", "", "run as coordinator and split the workload across these comma separated worker addresses")
+	fakeLeaf := flag.Bool("fake-leaf", false, "send the calls to an in-process fake Leaf configured by the fake_leaf section instead of the configured Leafs")
+	maxDeviation := flag.Float64("max-rate-deviation", internal.DefaultRateDeviation, "share by which a phase may miss its target rate before the run is marked invalid")
+	startDelay := flag.Duration("start-delay", internal.DefaultStartDelay, "time between sending the workload to the workers and their synchronized start")
 	flag.Parse()
 
 	logLevelInt := getLogLevel(*logLevel)
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	// the workers would send their calls to the configured Leafs, not to the coordinator's fake
+	if *fakeLeaf && *workers != "" {
+		log.Fatal("--fake-leaf cannot be combined with --workers")
+	}
+
+	logOut := os.Stdout
+	if *tui {
+		logFile, err := os.Create(*out + ".log")
+		if err != nil {
+			log.Fatalf("Failed to create log file: %v", err)
+		}
+		defer logFile.Close()
+		logOut = logFile
+	}
+
+	logger := slog.New(slog.NewTextHandler(logOut, &slog.HandlerOptions{
 		Level: slog.Level(logLevelInt),
 	}))
 
-	controller := internal.NewController(
-		logger,
-		internal.WithConfigFile(*config),
-		internal.WithCollector(internal.NewCollector(*out)),
+	if *worker != "" {
+		lis, err := net.Listen("tcp", *worker)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", *worker, err)
+		}
+		if err := internal.NewWorker(logger).Serve(lis); err != nil {
+			log.Fatalf("Worker failed: %v", err)
+		}
+		return
+	}
+
+	// served next to pprof on localhost:6060
+	metrics := internal.NewMetrics()
+	http.Handle("/metrics", metrics.Handler())
+	summary := internal.NewSummary()
+	accuracy := internal.NewAccuracy(*maxDeviation, logger)
+	instances := internal.NewInstanceTracker(10 * time.Second)
+
+	collector := internal.NewCollector(*out, *format,
+		internal.WithFlushInterval(*flushInterval),
+		internal.WithBufferSize(*collectorBuffer),
 	)
-	godump.Dump(controller.Config.Workload)
-	controller.Run()
+	if *hdrLog != "" {
+		if err := collector.WriteHistogramLog(*hdrLog, *hdrInterval); err != nil {
+			log.Fatalf("Failed to create histogram log: %v", err)
+		}
+	}
+
+	metrics.WatchCollector(collector)
+
+	opts := []internal.Option{
+		internal.WithConfigFile(*config),
+		internal.WithCollector(collector),
+		internal.WithObserver(metrics),
+		internal.WithObserver(summary),
+		internal.WithObserver(instances),
+		internal.WithObserver(accuracy),
+	}
+	if *fakeLeaf {
+		opts = append(opts, internal.WithFakeLeaf())
+	}
+	var dashboard *internal.Dashboard
+	if *tui {
+		dashboard = internal.NewDashboard(os.Stdout, 10*time.Second)
+		opts = append(opts, internal.WithObserver(dashboard))
+	}
+
+	controller := internal.NewController(logger, opts...)
+	if dashboard == nil {
+		godump.Dump(controller.Config.Workload)
+	}
+
+	// the first SIGINT/SIGTERM interrupts the run and drains in-flight calls,
+	// a second one terminates immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	dashboardCtx, stopDashboard := context.WithCancel(context.Background())
+	go metrics.Run(dashboardCtx)
+	go accuracy.Run(dashboardCtx)
+	dashboardDone := make(chan struct{})
+	go func() {
+		defer close(dashboardDone)
+		if dashboard != nil {
+			dashboard.Run(dashboardCtx)
+		}
+	}()
+
+	// a failed worker still leaves the results of the others to report
+	var runErr error
+	if *workers != "" {
+		coordinator := internal.NewCoordinator(controller, strings.Split(*workers, ","), *startDelay)
+		runErr = coordinator.Run(ctx)
+	} else {
+		controller.Run(ctx)
+	}
+	stop()
+	stopDashboard()
+	<-dashboardDone
+
+	report := summary.Report()
+	if err := report.WriteText(os.Stdout); err != nil {
+		logger.Error("Failed to print summary", "error", err)
+	}
+	instanceReport := instances.Report()
+	if len(instanceReport.ImageTags) > 0 {
+		if err := instanceReport.WriteText(os.Stdout); err != nil {
+			logger.Error("Failed to print instance report", "error", err)
+		}
+	}
+	accuracyReport := accuracy.Report()
+	if err := accuracyReport.WriteText(os.Stdout); err != nil {
+		logger.Error("Failed to print accuracy report", "error", err)
+	}
+	if *summaryFiles {
+		writeSummaryFile(logger, summaryPath(*out, ".json"), report.WriteJSON)
+		writeSummaryFile(logger, summaryPath(*out, ".md"), report.WriteMarkdown)
+		writeSummaryFile(logger, strings.TrimSuffix(*out, filepath.Ext(*out))+".instances.json", instanceReport.WriteJSON)
+		writeSummaryFile(logger, strings.TrimSuffix(*out, filepath.Ext(*out))+".accuracy.json", accuracyReport.WriteJSON)
+	}
+	if runErr != nil {
+		log.Fatalf("Failed to run the workload on the workers: %v", runErr)
+	}
+}
+
+// summaryPath places the summary next to the results file, results.csv becomes results.summary.json.
+func summaryPath(out string, ext string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + ".summary" + ext
+}
+
+func writeSummaryFile(logger *slog.Logger, path string, write func(io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		logger.Error("Failed to create summary file", "path", path, "error", err)
+		return
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		logger.Error("Failed to write summary file", "path", path, "error", err)
+	}
 }
 
 func getLogLevel(logLevel string) slog.Level {
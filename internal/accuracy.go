@@ -0,0 +1,361 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"runtime"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	// DefaultRateDeviation is how far the dispatched calls of a phase may be off
+	// its target before the run is marked invalid.
+	DefaultRateDeviation = 0.05
+	// cpuBoundShare of GOMAXPROCS in use marks the generator as CPU bound.
+	cpuBoundShare = 0.9
+	// usageInterval is the time between two samples of the CPU and goroutines.
+	usageInterval = time.Second
+)
+
+// Accuracy is a RunObserver that checks whether the generator kept up with the
+// workload: per phase it compares the calls actually dispatched with the calls
+// the phase should have sent, measures how late the calls left compared to
+// their schedule, and samples the CPU and goroutines of this process. A phase
+// that misses its target by more than the allowed deviation marks the run as
+// invalid.
+type Accuracy struct {
+	deviation float64
+	l         *slog.Logger
+	// dispatched counts the calls sent by each phase
+	dispatched callCounters
+
+	mu         sync.Mutex
+	phases     []TestPhase
+	byPhase    map[string]*accuracyStats
+	running    map[string]bool
+	generator  generatorUsage
+	lastSample time.Time
+	lastCPU    time.Duration
+	failures   []string
+}
+
+type accuracyStats struct {
+	started  time.Time
+	finished time.Time
+	dropped  int64
+	late     int64
+	// lateness holds how late the calls were sent compared to their schedule
+	lateness *hdrhistogram.Histogram
+	usage    generatorUsage
+}
+
+// generatorUsage accumulates the samples taken while something was running.
+type generatorUsage struct {
+	cpu           time.Duration
+	wall          time.Duration
+	peakCores     float64
+	maxGoroutines int
+}
+
+// NewAccuracy allows each phase to miss its target by the given share, 5% if 0.
+func NewAccuracy(deviation float64, l *slog.Logger) *Accuracy {
+	return &Accuracy{
+		deviation: cmp.Or(deviation, DefaultRateDeviation),
+		l:         l,
+		byPhase:   make(map[string]*accuracyStats),
+		running:   make(map[string]bool),
+	}
+}
+
+func (a *Accuracy) RunStarted(phases []TestPhase) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.phases = phases
+}
+
+func (a *Accuracy) PhaseStarted(phase TestPhase) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats(phase.Name).started = time.Now()
+	a.running[phase.Name] = true
+}
+
+func (a *Accuracy) PhaseFinished(phase TestPhase) {
+	a.mu.Lock()
+	stats := a.stats(phase.Name)
+	stats.finished = time.Now()
+	delete(a.running, phase.Name)
+	row := a.row(phase, stats)
+	a.mu.Unlock()
+
+	if row.Valid != nil && !*row.Valid {
+		a.l.Warn("Phase missed its target rate, the run is invalid", "Phase", phase.Name,
+			"Target", math.Round(*row.Target), "Dispatched", row.Dispatched, "Dropped", row.Dropped,
+			"Deviation", fmt.Sprintf("%+.1f%%", *row.Deviation*100))
+	}
+}
+
+func (a *Accuracy) CallStarted(phase TestPhase) {
+	a.dispatched.get(phase.Name).Add(1)
+}
+
+func (a *Accuracy) Collect(result CallResult) {
+	if result.Outcome == OutcomeInterrupted {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := a.stats(result.Phase)
+	switch result.Outcome {
+	case OutcomeDropped:
+		stats.dropped++
+		return
+	case OutcomeLate:
+		stats.late++
+	}
+	if !result.IntendedTimestamp.IsZero() {
+		stats.lateness.RecordValue(clampHistogramValue(result.SendDelay()))
+	}
+}
+
+func (a *Accuracy) stats(phase string) *accuracyStats {
+	stats, ok := a.byPhase[phase]
+	if !ok {
+		stats = &accuracyStats{lateness: hdrhistogram.New(histogramMin, histogramMax, histogramDigits)}
+		a.byPhase[phase] = stats
+	}
+	return stats
+}
+
+// RunFailed marks the run as invalid, part of its load is missing.
+func (a *Accuracy) RunFailed(failures []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failures = append(a.failures, failures...)
+}
+
+// Run samples the CPU time and goroutines of the process every second until ctx
+// is done.
+func (a *Accuracy) Run(ctx context.Context) {
+	t := time.NewTicker(usageInterval)
+	defer t.Stop()
+
+	a.sample(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			a.sample(now)
+		}
+	}
+}
+
+func (a *Accuracy) sample(now time.Time) {
+	cpu, ok := processCPUTime()
+	goroutines := runtime.NumGoroutine()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer func() {
+		a.lastSample, a.lastCPU = now, cpu
+	}()
+	if a.lastSample.IsZero() || !ok {
+		return
+	}
+
+	wall := now.Sub(a.lastSample)
+	used := cpu - a.lastCPU
+	add := func(u *generatorUsage) {
+		u.cpu += used
+		u.wall += wall
+		if wall > 0 {
+			u.peakCores = max(u.peakCores, used.Seconds()/wall.Seconds())
+		}
+		u.maxGoroutines = max(u.maxGoroutines, goroutines)
+	}
+	add(&a.generator)
+	for phase := range a.running {
+		add(&a.byPhase[phase].usage)
+	}
+}
+
+// tolerance is the deviation allowed for a phase that should have sent expected
+// calls. Random arrivals vary on their own, so they get three standard
+// deviations of a Poisson count on top. mmpp phases only have a long-run mean
+// rate and are not judged.
+func (a *Accuracy) tolerance(phase TestPhase, expected float64) (float64, bool) {
+	if phase.Type == "mmpp" || expected < 1 {
+		return 0, false
+	}
+	tolerance := a.deviation
+	if phase.Type == "poisson" || phase.Arrival == ArrivalExponential {
+		tolerance += 3 / math.Sqrt(expected)
+	}
+	return tolerance, true
+}
+
+// AccuracyReport tells whether the generator achieved the workload. Durations
+// are in nanoseconds in JSON.
+type AccuracyReport struct {
+	Valid        bool          `json:"valid"`
+	MaxDeviation float64       `json:"max_deviation"`
+	Phases       []AccuracyRow `json:"phases"`
+	Generator    UsageSummary  `json:"generator"`
+	Warnings     []string      `json:"warnings,omitempty"`
+}
+
+type AccuracyRow struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+	// Target is the number of calls the phase should have sent in the time it ran, nil without a rate target
+	Target     *float64 `json:"target,omitempty"`
+	Dispatched int64    `json:"dispatched"`
+	Dropped    int64    `json:"dropped"`
+	// Deviation is dispatched relative to the target, -0.1 is 10% too few
+	Deviation *float64 `json:"deviation,omitempty"`
+	// Valid is nil for phases that are not judged
+	Valid *bool `json:"valid,omitempty"`
+	// Late calls waited for an in-flight slot, Lateness is the delay of all calls behind their schedule
+	Late     int64          `json:"late"`
+	Lateness LatencySummary `json:"lateness"`
+	Usage    UsageSummary   `json:"usage"`
+}
+
+// UsageSummary is the CPU and goroutine usage of the process while something ran.
+type UsageSummary struct {
+	CPUCores      float64 `json:"cpu_cores"`      // mean CPU time per second
+	PeakCPUCores  float64 `json:"peak_cpu_cores"` // highest CPU time per second of a sample
+	MaxGoroutines int     `json:"max_goroutines"`
+	MaxProcs      int     `json:"gomaxprocs"`
+}
+
+// Report builds the report from everything seen so far.
+func (a *Accuracy) Report() AccuracyReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	report := AccuracyReport{
+		Valid:        true,
+		MaxDeviation: a.deviation,
+		Generator:    a.generator.summary(),
+	}
+	for _, phase := range a.phases {
+		stats := a.stats(phase.Name)
+		if stats.started.IsZero() {
+			// never started, e.g. skipped after an interrupt
+			continue
+		}
+		row := a.row(phase, stats)
+		if row.Valid != nil && !*row.Valid {
+			report.Valid = false
+			report.Warnings = append(report.Warnings, fmt.Sprintf("phase %s dispatched %d of %.0f calls (%+.1f%%, %d dropped)",
+				phase.Name, row.Dispatched, *row.Target, *row.Deviation*100, row.Dropped))
+		}
+		report.Phases = append(report.Phases, row)
+	}
+	for _, failure := range a.failures {
+		report.Valid = false
+		report.Warnings = append(report.Warnings, failure)
+	}
+	if g := report.Generator; g.PeakCPUCores >= cpuBoundShare*float64(g.MaxProcs) {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("the generator used up to %.1f of %d CPU cores and may have been CPU bound", g.PeakCPUCores, g.MaxProcs))
+	}
+	return report
+}
+
+func (a *Accuracy) row(phase TestPhase, stats *accuracyStats) AccuracyRow {
+	dispatched := a.dispatched.get(phase.Name).Load()
+	row := AccuracyRow{
+		Name:       phase.Name,
+		Type:       phase.Type,
+		Dispatched: dispatched,
+		Dropped:    stats.dropped,
+		Late:       stats.late,
+		Lateness:   summarizeHistogram(stats.lateness),
+		Usage:      stats.usage.summary(),
+	}
+
+	finished := stats.finished
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	// phases cut short by an interrupt are judged by the part that ran
+	expected, ok := expectedRequests(phase, finished.Sub(stats.started))
+	if !ok {
+		return row
+	}
+	row.Target = &expected
+	if expected > 0 {
+		deviation := float64(dispatched)/expected - 1
+		row.Deviation = &deviation
+	}
+	if tolerance, ok := a.tolerance(phase, expected); ok && !stats.finished.IsZero() {
+		valid := math.Abs(*row.Deviation) <= tolerance
+		row.Valid = &valid
+	}
+	return row
+}
+
+func (u generatorUsage) summary() UsageSummary {
+	s := UsageSummary{
+		PeakCPUCores:  u.peakCores,
+		MaxGoroutines: u.maxGoroutines,
+		MaxProcs:      runtime.GOMAXPROCS(0),
+	}
+	if u.wall > 0 {
+		s.CPUCores = u.cpu.Seconds() / u.wall.Seconds()
+	}
+	return s
+}
+
+// WriteText prints the verdict, a table per phase and the warnings.
+func (r AccuracyReport) WriteText(w io.Writer) error {
+	verdict := "valid"
+	if !r.Valid {
+		verdict = "INVALID"
+	}
+	fmt.Fprintf(w, "Generator accuracy: %s (phases within %.1f%% of their target)\n\n", verdict, r.MaxDeviation*100)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tTARGET\tDISPATCHED\tDROPPED\tDEVIATION\tLATE\tLATENESS P50\tP99\tMAX\tCPU CORES\tGOROUTINES")
+	for _, row := range r.Phases {
+		target, deviation := "-", "-"
+		if row.Target != nil {
+			target = fmt.Sprintf("%.0f", *row.Target)
+		}
+		if row.Deviation != nil {
+			deviation = fmt.Sprintf("%+.1f%%", *row.Deviation*100)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%d\t%v\t%v\t%v\t%.2f\t%d\n",
+			row.Name, target, row.Dispatched, row.Dropped, deviation, row.Late,
+			row.Lateness.P50, row.Lateness.P99, row.Lateness.Max, row.Usage.CPUCores, row.Usage.MaxGoroutines)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	g := r.Generator
+	fmt.Fprintf(w, "\nGenerator: %.2f CPU cores on average, %.2f at peak, GOMAXPROCS %d, up to %d goroutines\n", g.CPUCores, g.PeakCPUCores, g.MaxProcs, g.MaxGoroutines)
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r AccuracyReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccuracy_Report(t *testing.T) {
+	tests := []struct {
+		name       string
+		phase      TestPhase
+		dispatched int64
+		dropped    int64
+		judged     bool
+		valid      bool
+	}{
+		{"on target", TestPhase{Type: "constant", StartRPS: 100, Duration: 10 * time.Second}, 1000, 0, true, true},
+		{"within deviation", TestPhase{Type: "constant", StartRPS: 100, Duration: 10 * time.Second}, 960, 0, true, true},
+		{"too few", TestPhase{Type: "constant", StartRPS: 100, Duration: 10 * time.Second}, 800, 200, true, false},
+		{"too many", TestPhase{Type: "variable", StartRPS: 10, EndRPS: 100, Step: 10, Duration: 10 * time.Second}, 700, 0, true, false},
+		{"poisson noise", TestPhase{Type: "poisson", StartRPS: 10, Duration: 10 * time.Second}, 85, 0, true, true},
+		{"mmpp", TestPhase{Type: "mmpp", StartRPS: 10, BurstRPS: 100, BurstDuration: time.Second, IdleDuration: time.Second, Duration: 10 * time.Second}, 10, 0, false, false},
+		{"closed", TestPhase{Type: "closed", StartUsers: 10, Duration: 10 * time.Second}, 500, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.phase.Name = "phase"
+			a := NewAccuracy(0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			a.RunStarted([]TestPhase{tt.phase})
+			stats := a.stats(tt.phase.Name)
+			stats.started = time.Now().Add(-tt.phase.Duration - time.Second)
+			stats.finished = time.Now()
+			a.dispatched.get(tt.phase.Name).Store(tt.dispatched)
+			stats.dropped = tt.dropped
+
+			report := a.Report()
+			row := report.Phases[0]
+			if judged := row.Valid != nil; judged != tt.judged {
+				t.Fatalf("Expected judged to be %v, got %+v", tt.judged, row)
+			}
+			if tt.judged && (*row.Valid != tt.valid || report.Valid != tt.valid) {
+				t.Errorf("Expected valid to be %v, got %v for a deviation of %v", tt.valid, *row.Valid, *row.Deviation)
+			}
+			if !tt.judged && !report.Valid {
+				t.Errorf("Expected phases that are not judged to keep the run valid")
+			}
+			if !report.Valid && len(report.Warnings) == 0 {
+				t.Errorf("Expected a warning for an invalid run")
+			}
+		})
+	}
+}
+
+func TestAccuracy_InterruptedPhase(t *testing.T) {
+	a := NewAccuracy(0.1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	phase := TestPhase{Name: "cut", Type: "constant", StartRPS: 100, Duration: time.Minute}
+	a.RunStarted([]TestPhase{phase, {Name: "skipped", Type: "constant", StartRPS: 100, Duration: time.Minute}})
+	stats := a.stats(phase.Name)
+	stats.started = time.Now().Add(-10 * time.Second)
+	stats.finished = time.Now()
+	a.dispatched.get(phase.Name).Store(1000)
+
+	report := a.Report()
+	if len(report.Phases) != 1 {
+		t.Fatalf("Expected only the started phase, got %+v", report.Phases)
+	}
+	if row := report.Phases[0]; math.Round(*row.Target) != 1000 || !*row.Valid {
+		t.Errorf("Expected a target of 1000 for the 10s that ran, got %v", *row.Target)
+	}
+}
+
+func TestAccuracy_Collect(t *testing.T) {
+	a := NewAccuracy(0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	phase := TestPhase{Name: "steady", Type: "constant", StartRPS: 3, Duration: time.Second}
+	a.RunStarted([]TestPhase{phase})
+	a.PhaseStarted(phase)
+	intended := time.Now()
+	for _, r := range []CallResult{
+		{Outcome: OutcomeSent, Attempts: 1, IntendedTimestamp: intended, Timestamp: intended.Add(time.Millisecond)},
+		{Outcome: OutcomeLate, Attempts: 1, IntendedTimestamp: intended, Timestamp: intended.Add(20 * time.Millisecond)},
+		{Outcome: OutcomeSent, Attempts: 3, IntendedTimestamp: intended, FirstSentTimestamp: intended.Add(2 * time.Millisecond), Timestamp: intended.Add(time.Second)},
+		{Outcome: OutcomeDropped, IntendedTimestamp: intended, Timestamp: intended},
+	} {
+		if r.Outcome != OutcomeDropped {
+			a.CallStarted(phase)
+		}
+		r.Phase = phase.Name
+		a.Collect(r)
+	}
+	a.PhaseFinished(phase)
+
+	row := a.Report().Phases[0]
+	if row.Dispatched != 3 || row.Dropped != 1 || row.Late != 1 {
+		t.Errorf("Expected 3 dispatched, 1 dropped and 1 late call, got %+v", row)
+	}
+	if row.Lateness.Max < 19*time.Millisecond || row.Lateness.Max > 21*time.Millisecond {
+		t.Errorf("Expected the lateness of the retried call's first attempt, got a max of %v", row.Lateness.Max)
+	}
+}
+
+func TestAccuracy_Sample(t *testing.T) {
+	a := NewAccuracy(0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	phase := TestPhase{Name: "busy"}
+	a.PhaseStarted(phase)
+	start := time.Now()
+	a.sample(start)
+	for time.Since(start) < 50*time.Millisecond {
+	}
+	a.sample(time.Now())
+
+	report := a.Report()
+	if _, ok := processCPUTime(); ok && report.Generator.CPUCores <= 0 {
+		t.Errorf("Expected CPU usage while spinning, got %+v", report.Generator)
+	}
+	if usage := a.byPhase[phase.Name].usage; usage.maxGoroutines == 0 || usage.wall == 0 {
+		t.Errorf("Expected the sample to count for the running phase, got %+v", usage)
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Generator accuracy: valid") {
+		t.Errorf("Expected a valid verdict, got %s", out.String())
+	}
+}
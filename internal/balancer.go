@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+
+	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// BalanceRoundRobin sends the calls to the Leafs in turn.
+	BalanceRoundRobin = "round_robin"
+	// BalanceRandom picks a Leaf at random for every call.
+	BalanceRandom = "random"
+	// BalanceLeastOutstanding picks the Leaf with the fewest calls in flight.
+	BalanceLeastOutstanding = "least_outstanding"
+	// BalanceConsistentHash sends all calls of a function to the same Leaf.
+	BalanceConsistentHash = "consistent_hash"
+)
+
+// ringReplicas is the number of points each Leaf gets on the hash ring, which
+// evens out the share of functions per Leaf.
+const ringReplicas = 100
+
+func validateLoadBalancing(strategy string) error {
+	switch strategy {
+	case "", BalanceRoundRobin, BalanceRandom, BalanceLeastOutstanding, BalanceConsistentHash:
+		return nil
+	default:
+		return fmt.Errorf("load balancing must be %s, %s, %s or %s, got %q",
+			BalanceRoundRobin, BalanceRandom, BalanceLeastOutstanding, BalanceConsistentHash, strategy)
+	}
+}
+
+// LeafBalancer spreads the calls over several Leafs. Every result records the
+// address of the Leaf that served it as its Target.
+type LeafBalancer struct {
+	strategy string
+	targets  []*leafTarget
+	next     atomic.Uint64
+	ring     []ringPoint // sorted by hash
+}
+
+type leafTarget struct {
+	client      Client
+	outstanding atomic.Int64
+}
+
+type ringPoint struct {
+	hash   uint64
+	target int
+}
+
+// NewLeafBalancer connects to every address. An empty strategy is round-robin.
+func NewLeafBalancer(addresses []string, strategy string, connection *ConnectionConfig, creds credentials.TransportCredentials) *LeafBalancer {
+	clients := make([]Client, len(addresses))
+	for i, address := range addresses {
+		clients[i] = NewLeafClient(address, connection, creds)
+	}
+	return newLeafBalancer(clients, addresses, strategy)
+}
+
+func newLeafBalancer(clients []Client, addresses []string, strategy string) *LeafBalancer {
+	b := &LeafBalancer{strategy: strategy}
+	for i, c := range clients {
+		b.targets = append(b.targets, &leafTarget{client: c})
+		if strategy == BalanceConsistentHash {
+			for r := range ringReplicas {
+				b.ring = append(b.ring, ringPoint{hash: hashKey(fmt.Sprintf("%s#%d", addresses[i], r)), target: i})
+			}
+		}
+	}
+	slices.SortFunc(b.ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return b
+}
+
+func (b *LeafBalancer) ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRequest) (CallResult, error) {
+	target := b.targets[b.pick(req.FunctionID.Id)]
+	target.outstanding.Add(1)
+	defer target.outstanding.Add(-1)
+	return target.client.ScheduleCall(ctx, req)
+}
+
+// pick returns the index of the Leaf for the next call to functionID.
+func (b *LeafBalancer) pick(functionID string) int {
+	n := len(b.targets)
+	if n == 1 {
+		return 0
+	}
+	switch b.strategy {
+	case BalanceRandom:
+		return rand.IntN(n)
+	case BalanceLeastOutstanding:
+		// start the scan at a rotating offset so ties are spread evenly
+		start := int(b.next.Add(1) % uint64(n))
+		best := start
+		for i := 1; i < n; i++ {
+			j := (start + i) % n
+			if b.targets[j].outstanding.Load() < b.targets[best].outstanding.Load() {
+				best = j
+			}
+		}
+		return best
+	case BalanceConsistentHash:
+		h := hashKey(functionID)
+		i, _ := slices.BinarySearchFunc(b.ring, h, func(p ringPoint, h uint64) int {
+			return cmp.Compare(p.hash, h)
+		})
+		return b.ring[i%len(b.ring)].target
+	default:
+		return int((b.next.Add(1) - 1) % uint64(n))
+	}
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
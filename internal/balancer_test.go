@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/3s-rg-codes/HyperFaaS/proto/common"
+	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
+)
+
+// targetClient answers like a Leaf at address, optionally holding calls until release is closed.
+type targetClient struct {
+	address string
+	release chan struct{}
+}
+
+func (c *targetClient) ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRequest) (CallResult, error) {
+	if c.release != nil {
+		<-c.release
+	}
+	return CallResult{FunctionID: req.FunctionID.Id, Target: c.address}, nil
+}
+
+func newTestBalancer(strategy string, n int) *LeafBalancer {
+	clients := make([]Client, n)
+	addresses := make([]string, n)
+	for i := range n {
+		addresses[i] = fmt.Sprintf("leaf-%d:50050", i)
+		clients[i] = &targetClient{address: addresses[i]}
+	}
+	return newLeafBalancer(clients, addresses, strategy)
+}
+
+func callTargets(b *LeafBalancer, functionIDs ...string) map[string]int {
+	counts := make(map[string]int)
+	for _, id := range functionIDs {
+		result, _ := b.ScheduleCall(context.Background(), &leaf.ScheduleCallRequest{FunctionID: &common.FunctionID{Id: id}})
+		counts[result.Target]++
+	}
+	return counts
+}
+
+func TestLeafBalancer_RoundRobin(t *testing.T) {
+	b := newTestBalancer(BalanceRoundRobin, 3)
+	ids := make([]string, 30)
+	for i := range ids {
+		ids[i] = "f1"
+	}
+	counts := callTargets(b, ids...)
+	if len(counts) != 3 {
+		t.Fatalf("Expected calls on 3 Leafs, got %v", counts)
+	}
+	for target, n := range counts {
+		if n != 10 {
+			t.Errorf("Expected 10 calls on %s, got %d", target, n)
+		}
+	}
+}
+
+func TestLeafBalancer_ConsistentHash(t *testing.T) {
+	b := newTestBalancer(BalanceConsistentHash, 3)
+	for _, id := range []string{"f1", "f2", "f3", "f4"} {
+		if counts := callTargets(b, id, id, id, id, id); len(counts) != 1 {
+			t.Errorf("Expected all calls of %s on one Leaf, got %v", id, counts)
+		}
+	}
+
+	ids := make([]string, 300)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("function-%d", i)
+	}
+	for target, n := range callTargets(b, ids...) {
+		if n < 50 {
+			t.Errorf("Expected functions spread over the Leafs, %s got only %d of 300", target, n)
+		}
+	}
+}
+
+func TestLeafBalancer_LeastOutstanding(t *testing.T) {
+	release := make(chan struct{})
+	busy := &targetClient{address: "busy:50050", release: release}
+	idle := &targetClient{address: "idle:50050"}
+	b := newLeafBalancer([]Client{busy, idle}, []string{busy.address, idle.address}, BalanceLeastOutstanding)
+
+	// park a call on busy
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			result, _ := b.ScheduleCall(context.Background(), &leaf.ScheduleCallRequest{FunctionID: &common.FunctionID{Id: "f1"}})
+			if result.Target == busy.address {
+				return
+			}
+		}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for b.targets[0].outstanding.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a call to be parked on the busy Leaf")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if counts := callTargets(b, "f1", "f1", "f1", "f1"); counts[idle.address] != 4 {
+		t.Errorf("Expected all calls on the idle Leaf while the other one is busy, got %v", counts)
+	}
+	close(release)
+	<-done
+}
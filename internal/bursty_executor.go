@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// PoissonExecutor sends requests with exponentially distributed inter-arrival
+// times at a mean rate of StartRPS.
+type PoissonExecutor struct {
+	stopper
+	client       Client
+	collector    DataCollector
+	funcMgr      *FunctionManager
+	l            *slog.Logger
+	dataProvider DataProvider
+	limiter      *InFlightLimiter
+}
+
+func NewPoissonExecutor(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) *PoissonExecutor {
+	return &PoissonExecutor{
+		stopper:      newStopper(),
+		client:       client,
+		collector:    collector,
+		funcMgr:      funcMgr,
+		l:            l,
+		dataProvider: dataProvider,
+		limiter:      limiter,
+	}
+}
+
+func (e *PoissonExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	ctx, runCtx, finish := e.begin(ctx, phase.DrainTimeout)
+	subCtx, cancel := context.WithTimeout(runCtx, phase.Duration)
+	defer cancel()
+
+	e.l.Debug("Poisson executor", "Mean RPS", phase.StartRPS)
+	scheduler := NewArrivalScheduler(time.Now(), ArrivalExponential, uint64(time.Now().UnixNano()))
+	scheduler.RunPiecewise(subCtx, func(time.Duration) (float64, time.Duration) {
+		return float64(phase.StartRPS), phase.Duration
+	}, func(intended time.Time) {
+		dispatchCall(ctx, subCtx, &e.calls, e.limiter, e.client, e.collector, e.dataProvider, phase, intended)
+	})
+	return finish()
+}
+
+// MMPPExecutor models a two state Markov-modulated Poisson process. The phase
+// alternates between an idle state at StartRPS and a burst state at BurstRPS,
+// staying in each for an exponentially distributed time with mean IdleDuration
+// and BurstDuration respectively.
+type MMPPExecutor struct {
+	stopper
+	client       Client
+	collector    DataCollector
+	funcMgr      *FunctionManager
+	l            *slog.Logger
+	dataProvider DataProvider
+	limiter      *InFlightLimiter
+}
+
+func NewMMPPExecutor(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) *MMPPExecutor {
+	return &MMPPExecutor{
+		stopper:      newStopper(),
+		client:       client,
+		collector:    collector,
+		funcMgr:      funcMgr,
+		l:            l,
+		dataProvider: dataProvider,
+		limiter:      limiter,
+	}
+}
+
+func (e *MMPPExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	ctx, runCtx, finish := e.begin(ctx, phase.DrainTimeout)
+	subCtx, cancel := context.WithTimeout(runCtx, phase.Duration)
+	defer cancel()
+
+	seed := uint64(time.Now().UnixNano())
+	states := newMMPPStates(rand.New(rand.NewPCG(seed, seed+1)), phase)
+	scheduler := NewArrivalScheduler(time.Now(), ArrivalExponential, seed)
+	var lastUntil time.Duration
+	scheduler.RunPiecewise(subCtx, func(elapsed time.Duration) (float64, time.Duration) {
+		bursting, until := states.at(elapsed)
+		if until != lastUntil {
+			lastUntil = until
+			e.l.Debug("MMPP executor", "Phase", phase.Name, "Bursting", bursting, "Until", until)
+		}
+		if bursting {
+			return float64(phase.BurstRPS), until
+		}
+		return float64(phase.StartRPS), until
+	}, func(intended time.Time) {
+		dispatchCall(ctx, subCtx, &e.calls, e.limiter, e.client, e.collector, e.dataProvider, phase, intended)
+	})
+	return finish()
+}
+
+// mmppStates lazily samples the idle/burst timeline of an MMPP phase.
+type mmppStates struct {
+	random   *rand.Rand
+	idle     time.Duration
+	burst    time.Duration
+	bursting bool
+	until    time.Duration
+}
+
+func newMMPPStates(random *rand.Rand, phase TestPhase) *mmppStates {
+	m := &mmppStates{
+		random: random,
+		idle:   phase.IdleDuration,
+		burst:  phase.BurstDuration,
+	}
+	m.until = m.sample(m.idle)
+	return m
+}
+
+// at returns the state at elapsed, which must not decrease between calls.
+func (m *mmppStates) at(elapsed time.Duration) (bursting bool, until time.Duration) {
+	for elapsed >= m.until {
+		m.bursting = !m.bursting
+		if m.bursting {
+			m.until += m.sample(m.burst)
+		} else {
+			m.until += m.sample(m.idle)
+		}
+	}
+	return m.bursting, m.until
+}
+
+func (m *mmppStates) sample(mean time.Duration) time.Duration {
+	d := time.Duration(m.random.ExpFloat64() * float64(mean))
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return d
+}
+
+// SpikeExecutor sends a baseline of StartRPS and raises it to BurstRPS for
+// BurstDuration every BurstInterval, starting one interval into the phase.
+type SpikeExecutor struct {
+	stopper
+	client       Client
+	collector    DataCollector
+	funcMgr      *FunctionManager
+	l            *slog.Logger
+	dataProvider DataProvider
+	limiter      *InFlightLimiter
+}
+
+func NewSpikeExecutor(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) *SpikeExecutor {
+	return &SpikeExecutor{
+		stopper:      newStopper(),
+		client:       client,
+		collector:    collector,
+		funcMgr:      funcMgr,
+		l:            l,
+		dataProvider: dataProvider,
+		limiter:      limiter,
+	}
+}
+
+func (e *SpikeExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	ctx, runCtx, finish := e.begin(ctx, phase.DrainTimeout)
+	subCtx, cancel := context.WithTimeout(runCtx, phase.Duration)
+	defer cancel()
+
+	scheduler := NewArrivalScheduler(time.Now(), phase.Arrival, uint64(time.Now().UnixNano()))
+	scheduler.RunPiecewise(subCtx, func(elapsed time.Duration) (float64, time.Duration) {
+		spiking, until := spikeAt(phase.BurstInterval, phase.BurstDuration, elapsed)
+		if spiking {
+			return float64(phase.BurstRPS), until
+		}
+		return float64(phase.StartRPS), until
+	}, func(intended time.Time) {
+		dispatchCall(ctx, subCtx, &e.calls, e.limiter, e.client, e.collector, e.dataProvider, phase, intended)
+	})
+	return finish()
+}
+
+// spikeAt reports whether a spike is active at elapsed and when that changes.
+// Spikes start at every multiple of interval after the first.
+func spikeAt(interval, duration, elapsed time.Duration) (spiking bool, until time.Duration) {
+	if interval <= 0 {
+		return false, math.MaxInt64
+	}
+	period := elapsed / interval
+	periodStart := period * interval
+	if period > 0 && elapsed < periodStart+duration {
+		return true, periodStart + duration
+	}
+	return false, periodStart + interval
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestSpikeAt(t *testing.T) {
+	interval, duration := 10*time.Second, 2*time.Second
+	tests := []struct {
+		elapsed         time.Duration
+		expectedSpiking bool
+		expectedUntil   time.Duration
+	}{
+		{0, false, 10 * time.Second},
+		{9 * time.Second, false, 10 * time.Second},
+		{10 * time.Second, true, 12 * time.Second},
+		{11 * time.Second, true, 12 * time.Second},
+		{12 * time.Second, false, 20 * time.Second},
+		{21 * time.Second, true, 22 * time.Second},
+	}
+	for _, tt := range tests {
+		spiking, until := spikeAt(interval, duration, tt.elapsed)
+		if spiking != tt.expectedSpiking || until != tt.expectedUntil {
+			t.Errorf("spikeAt(%v) = (%v, %v), expected (%v, %v)", tt.elapsed, spiking, until, tt.expectedSpiking, tt.expectedUntil)
+		}
+	}
+}
+
+func TestMMPPStates_Alternate(t *testing.T) {
+	states := newMMPPStates(rand.New(rand.NewPCG(1, 2)), TestPhase{
+		BurstDuration: time.Second,
+		IdleDuration:  4 * time.Second,
+	})
+
+	var burst, idle time.Duration
+	var elapsed time.Duration
+	previous := false
+	for elapsed < time.Hour {
+		bursting, until := states.at(elapsed)
+		if until <= elapsed {
+			t.Fatalf("State at %v ends at %v, expected it to end later", elapsed, until)
+		}
+		if elapsed > 0 && bursting == previous {
+			t.Fatalf("Expected state to alternate at %v", elapsed)
+		}
+		if bursting {
+			burst += until - elapsed
+		} else {
+			idle += until - elapsed
+		}
+		previous = bursting
+		elapsed = until
+	}
+
+	// idle periods are 4x longer on average, allow a generous margin
+	ratio := float64(burst) / float64(burst+idle)
+	if ratio < 0.15 || ratio > 0.25 {
+		t.Errorf("Expected roughly 20%% of time in bursts, got %.1f%%", ratio*100)
+	}
+}
@@ -2,50 +2,82 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+// LeafClient sends calls to one Leaf over a pool of connections that take turns.
 type LeafClient struct {
+	address string
+	conns   []*leafConn
+	next    atomic.Uint64
+}
+
+type leafConn struct {
 	conn   *grpc.ClientConn
 	client leaf.LeafClient
+	// streams holds a token per call in flight if the concurrent streams are capped
+	streams chan struct{}
 }
 
-func NewLeafClient(address string) *LeafClient {
-	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalf("Failed to connect to Leaf: %v", err)
-	}
-	return &LeafClient{
-		conn:   conn,
-		client: leaf.NewLeafClient(conn),
+func NewLeafClient(address string, connection *ConnectionConfig, creds credentials.TransportCredentials) *LeafClient {
+	lc := &LeafClient{address: address}
+	for range connection.poolSize() {
+		conn, err := grpc.NewClient(address, connection.dialOptions(creds)...)
+		if err != nil {
+			log.Fatalf("Failed to connect to Leaf: %v", err)
+		}
+		c := &leafConn{conn: conn, client: leaf.NewLeafClient(conn)}
+		if connection != nil && connection.MaxConcurrentStreams > 0 {
+			c.streams = make(chan struct{}, connection.MaxConcurrentStreams)
+		}
+		lc.conns = append(lc.conns, c)
 	}
+	return lc
 }
 
 func (lc *LeafClient) Close() error {
-	return lc.conn.Close()
+	var errs []error
+	for _, c := range lc.conns {
+		errs = append(errs, c.conn.Close())
+	}
+	return errors.Join(errs...)
 }
 
 func (lc *LeafClient) ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRequest) (CallResult, error) {
 	var trailers metadata.MD
 	var headers metadata.MD
 
+	i := int((lc.next.Add(1) - 1) % uint64(len(lc.conns)))
+	c := lc.conns[i]
+
 	start := time.Now()
-	resp, err := lc.client.ScheduleCall(ctx, req,
-		grpc.Header(&headers),
-		grpc.Trailer(&trailers))
+	// waiting for a free stream counts towards the latency like waiting for the Leaf's stream quota
+	err := c.acquire(ctx)
+	var resp *leaf.ScheduleCallResponse
+	if err == nil {
+		resp, err = c.client.ScheduleCall(ctx, req,
+			grpc.Header(&headers),
+			grpc.Trailer(&trailers))
+		c.release()
+	}
 	latency := time.Since(start)
 
 	if err != nil {
 		return CallResult{
 			Timestamp:    start,
 			FunctionID:   req.FunctionID.Id,
+			Target:       lc.address,
+			Connection:   i,
 			Latency:      latency,
 			Status:       status.Code(err),
 			Error:        err.Error(),
@@ -57,20 +89,40 @@ func (lc *LeafClient) ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRe
 	result := CallResult{
 		Timestamp:                  start,
 		FunctionID:                 req.FunctionID.Id,
+		Target:                     lc.address,
+		Connection:                 i,
 		Latency:                    latency,
 		Status:                     status.Code(err),
 		ResponseSize:               int64(len(resp.Data)),
-		CallQueuedTimestamp:        getTrailerValue(trailers, "callQueuedTimestamp"),
-		GotResponseTimestamp:       getTrailerValue(trailers, "gotResponseTimestamp"),
+		CallQueuedTimestamp:        getTrailerTime(trailers, "callQueuedTimestamp"),
+		GotResponseTimestamp:       getTrailerTime(trailers, "gotResponseTimestamp"),
 		InstanceID:                 getTrailerValue(trailers, "instanceId"),
-		LeafGotRequestTimestamp:    getTrailerValue(trailers, "leafGotRequestTimestamp"),
-		LeafScheduledCallTimestamp: getTrailerValue(trailers, "leafScheduledCallTimestamp"),
-		FunctionProcessingTime:     getTrailerValue(trailers, "functionProcessingTime"),
+		LeafGotRequestTimestamp:    getTrailerTime(trailers, "leafGotRequestTimestamp"),
+		LeafScheduledCallTimestamp: getTrailerTime(trailers, "leafScheduledCallTimestamp"),
+		FunctionProcessingTime:     getTrailerDuration(trailers, "functionProcessingTime"),
 	}
 
 	return result, err
 }
 
+func (c *leafConn) acquire(ctx context.Context) error {
+	if c.streams == nil {
+		return nil
+	}
+	select {
+	case c.streams <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (c *leafConn) release() {
+	if c.streams != nil {
+		<-c.streams
+	}
+}
+
 func getTrailerValue(md metadata.MD, key string) string {
 	values := md.Get(key)
 	if len(values) > 0 {
@@ -79,3 +131,53 @@ func getTrailerValue(md metadata.MD, key string) string {
 	log.Println("No trailer value found for key:", key)
 	return ""
 }
+
+func getTrailerTime(md metadata.MD, key string) time.Time {
+	value := getTrailerValue(md, key)
+	t, ok := parseTrailerTime(value)
+	if !ok && value != "" {
+		log.Printf("Invalid timestamp %q in trailer %s", value, key)
+	}
+	return t
+}
+
+func getTrailerDuration(md metadata.MD, key string) time.Duration {
+	value := getTrailerValue(md, key)
+	d, ok := parseTrailerDuration(value)
+	if !ok && value != "" {
+		log.Printf("Invalid duration %q in trailer %s", value, key)
+	}
+	return d
+}
+
+// parseTrailerTime parses a HyperFaaS trailer timestamp, sent either as unix
+// nanoseconds or in RFC3339 format.
+func parseTrailerTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if ns, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, ns), true
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseTrailerDuration parses a HyperFaaS trailer duration, sent either as
+// nanoseconds or as a Go duration string.
+func parseTrailerDuration(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if ns, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ns), true
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTrailerTime(t *testing.T) {
+	expected := time.Date(2025, 7, 1, 12, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"1751371200123456789", true},
+		{"2025-07-01T12:00:00.123456789Z", true},
+		{"", false},
+		{"yesterday", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTrailerTime(tt.value)
+		if ok != tt.ok || ok && !got.Equal(expected) {
+			t.Errorf("parseTrailerTime(%q) = (%v, %v), expected (%v, %v)", tt.value, got, ok, expected, tt.ok)
+		}
+	}
+}
+
+func TestParseTrailerDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"1500000", 1500 * time.Microsecond, true},
+		{"1.5ms", 1500 * time.Microsecond, true},
+		{"", 0, false},
+		{"fast", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTrailerDuration(tt.value)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("parseTrailerDuration(%q) = (%v, %v), expected (%v, %v)", tt.value, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
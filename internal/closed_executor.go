@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// ClosedExecutor runs a closed-loop workload: every virtual user sends a call,
+// waits for the response, sleeps ThinkTime and repeats. The number of users
+// moves from StartUsers towards EndUsers by Step every second.
+type ClosedExecutor struct {
+	stopper
+	client       Client
+	collector    DataCollector
+	funcMgr      *FunctionManager
+	l            *slog.Logger
+	dataProvider DataProvider
+	limiter      *InFlightLimiter
+}
+
+func NewClosedExecutor(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) *ClosedExecutor {
+	return &ClosedExecutor{
+		stopper:      newStopper(),
+		client:       client,
+		collector:    collector,
+		funcMgr:      funcMgr,
+		l:            l,
+		dataProvider: dataProvider,
+		limiter:      limiter,
+	}
+}
+
+func (e *ClosedExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	ctx, runCtx, finish := e.begin(ctx, phase.DrainTimeout)
+	subCtx, cancel := context.WithTimeout(runCtx, phase.Duration)
+	defer cancel()
+
+	// cancelling a user lets its current call finish but stops the loop
+	var users []context.CancelFunc
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for second := 0; ; second++ {
+		target := max(phase.ramp(phase.StartUsers, phase.EndUsers, second), 0)
+		if target != len(users) {
+			e.l.Debug("Closed executor", "Phase", phase.Name, "Users", target)
+		}
+		for len(users) < target {
+			userCtx, cancelUser := context.WithCancel(subCtx)
+			users = append(users, cancelUser)
+			e.calls.Add(1)
+			go func() {
+				defer e.calls.Done()
+				e.runUser(ctx, userCtx, phase)
+			}()
+		}
+		for len(users) > target {
+			users[len(users)-1]()
+			users = users[:len(users)-1]
+		}
+
+		select {
+		case <-subCtx.Done():
+			return finish()
+		case <-t.C:
+		}
+	}
+}
+
+func (e *ClosedExecutor) runUser(ctx context.Context, userCtx context.Context, phase TestPhase) {
+	var think *time.Timer
+	if phase.ThinkTime > 0 {
+		think = time.NewTimer(phase.ThinkTime)
+		defer think.Stop()
+	}
+
+	for userCtx.Err() == nil {
+		intended := time.Now()
+		err := e.limiter.Do(userCtx, func(waited bool) {
+			sendCall(ctx, e.client, e.collector, e.dataProvider, phase, intended, waited)
+		})
+		if err != nil {
+			return
+		}
+
+		if think == nil {
+			continue
+		}
+		think.Reset(phase.ThinkTime)
+		select {
+		case <-userCtx.Done():
+			return
+		case <-think.C:
+		}
+	}
+}
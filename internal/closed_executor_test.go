@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
+)
+
+type slowClient struct {
+	latency  time.Duration
+	inFlight atomic.Int64
+	peak     atomic.Int64
+}
+
+func (c *slowClient) ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRequest) (CallResult, error) {
+	n := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		peak := c.peak.Load()
+		if n <= peak || c.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	start := time.Now()
+	time.Sleep(c.latency)
+	return CallResult{Timestamp: start, FunctionID: req.FunctionID.Id, Latency: time.Since(start)}, nil
+}
+
+type memoryCollector struct {
+	mu      sync.Mutex
+	results []CallResult
+}
+
+func (c *memoryCollector) Collect(result CallResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, result)
+}
+
+func TestClosedExecutor_LimitsConcurrencyToUsers(t *testing.T) {
+	client := &slowClient{latency: 10 * time.Millisecond}
+	collector := &memoryCollector{}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor := NewClosedExecutor(client, collector, nil, l, NewEchoDataProvider(8, 16), nil)
+
+	err := executor.Execute(context.Background(), TestPhase{
+		Name:       "closed",
+		Type:       "closed",
+		Duration:   300 * time.Millisecond,
+		StartUsers: 3,
+		ImageTag:   "test:latest",
+	})
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+	time.Sleep(2 * client.latency)
+
+	if peak := client.peak.Load(); peak != 3 {
+		t.Errorf("Expected at most and at least 3 concurrent calls, got a peak of %d", peak)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	// 3 users * 300ms / 10ms per call, with plenty of slack for slow machines
+	if len(collector.results) < 30 || len(collector.results) > 93 {
+		t.Errorf("Expected roughly 90 calls, got %d", len(collector.results))
+	}
+}
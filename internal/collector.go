@@ -1,98 +1,430 @@
 package internal
 
 import (
-	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc/codes"
 )
 
 var (
-	CSV_HEADERS = []string{"timestamp", "function_id", "image_tag", "latency_ms", "status", "error", "request_size_bytes", "response_size_bytes", "call_queued_timestamp", "got_response_timestamp", "instance_id", "leaf_got_request_timestamp", "leaf_scheduled_call_timestamp", "function_processing_time_ns"}
+	CSV_HEADERS = []string{"timestamp", "function_id", "image_tag", "latency_ms", "status", "error", "request_size_bytes", "response_size_bytes", "call_queued_timestamp", "got_response_timestamp", "instance_id", "leaf_got_request_timestamp", "leaf_scheduled_call_timestamp", "function_processing_time_ns", "intended_timestamp", "send_delay_ns", "outcome", "phase", "network_to_leaf_ns", "leaf_scheduling_ns", "queueing_ns", "function_execution_ns", "response_path_ns", "cold_start", "target", "connection", "attempts"}
 )
 
+const (
+	// defaultCollectorBuffer is the number of results that can wait for the writer.
+	defaultCollectorBuffer = 65536
+	// collectorBatchSize is the most results the writer takes off the buffer at once.
+	collectorBatchSize = 1024
+	// defaultFlushInterval is how often the writer flushes the sink.
+	defaultFlushInterval = time.Second
+)
+
+// Collector hands results from the call goroutines to a single writer goroutine
+// through a buffered channel, so that calls never wait for the sink or the
+// observers. The writer records the histograms, writes the results to the sink
+// in batches, passes them on to the observers and flushes the sink every flush
+// interval.
 type Collector struct {
-	csvWriter *csv.Writer
-	fileName  string
-	file      *os.File
-	mutex     sync.Mutex
-	headers   []string
+	sink          Sink
+	fileName      string
+	flushInterval time.Duration
+	// observers are set before the first result and read by the writer
+	observers []RunObserver
+
+	results   chan CallResult
+	stop      chan struct{}
+	done      chan struct{}
+	closed    atomic.Bool
+	closeOnce sync.Once
+
+	// backpressure: how often and how long Collect waited for a full buffer
+	blocked     atomic.Int64
+	blockedTime atomic.Int64
+	written     atomic.Int64
+
+	// latency histograms of the current window and of all finished windows,
+	// written by the writer goroutine
+	histMu            sync.Mutex
+	window            HistogramSet
+	windowStart       time.Time
+	histogramInterval time.Duration
+	histograms        HistogramSet
+	histogramLog      *histogramLog
+	histogramFile     *os.File
 }
 
-func NewCollector(fileName string) *Collector {
-	file, err := os.Create(fileName)
+type CollectorOption func(*Collector)
+
+// WithFlushInterval sets how often the sink is flushed.
+func WithFlushInterval(interval time.Duration) CollectorOption {
+	return func(c *Collector) {
+		if interval > 0 {
+			c.flushInterval = interval
+		}
+	}
+}
+
+// WithBufferSize sets how many results may wait for the writer before Collect blocks.
+func WithBufferSize(size int) CollectorOption {
+	return func(c *Collector) {
+		if size > 0 {
+			c.results = make(chan CallResult, size)
+		}
+	}
+}
+
+// NewCollector writes results to fileName in the given format, see NewSink, and
+// starts the writer goroutine.
+func NewCollector(fileName string, format string, opts ...CollectorOption) *Collector {
+	sink, err := NewSink(fileName, format)
 	if err != nil {
 		log.Fatalf("Failed to create results file: %v", err)
 	}
-	writer := csv.NewWriter(file)
-	writer.Write(CSV_HEADERS)
-	writer.Flush()
-	return &Collector{
-		csvWriter: writer,
-		fileName:  fileName,
-		file:      file,
-		headers:   CSV_HEADERS,
+	c := NewCollectorForSink(sink, opts...)
+	c.fileName = fileName
+	return c
+}
+
+// NewCollectorForSink writes results to sink, for example to stream them to a
+// coordinator, and starts the writer goroutine.
+func NewCollectorForSink(sink Sink, opts ...CollectorOption) *Collector {
+	c := &Collector{
+		sink:          sink,
+		flushInterval: defaultFlushInterval,
+		results:       make(chan CallResult, defaultCollectorBuffer),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+
+		window:            make(HistogramSet),
+		windowStart:       time.Now(),
+		histogramInterval: defaultHistogramInterval,
+		histograms:        make(HistogramSet),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	go c.run()
+	return c
+}
+
+// observe passes every result to the observers after it was written, and the
+// calls that are sent as they start. It must be called before the first result.
+func (c *Collector) observe(observers []RunObserver) {
+	c.observers = observers
+}
+
+// CallStarted tells the observers that a call of phase is about to be sent.
+func (c *Collector) CallStarted(phase TestPhase) {
+	for _, o := range c.observers {
+		o.CallStarted(phase)
+	}
+}
+
+// WriteHistogramLog makes the collector write its latency histograms to an
+// HdrHistogram interval log, one window per interval.
+func (c *Collector) WriteHistogramLog(fileName string, interval time.Duration) error {
+	c.histMu.Lock()
+	defer c.histMu.Unlock()
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	hlog, err := newHistogramLog(file, c.windowStart)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	c.histogramFile = file
+	c.histogramLog = hlog
+	if interval > 0 {
+		c.histogramInterval = interval
+	}
+	return nil
+}
+
+// Histograms returns a copy of the latency histograms of the run so far.
+func (c *Collector) Histograms() HistogramSet {
+	c.histMu.Lock()
+	defer c.histMu.Unlock()
+
+	set := make(HistogramSet)
+	set.Merge(c.histograms)
+	set.Merge(c.window)
+	return set
+}
+
+// rotateHistograms ends the current window at now, logs it and merges it into
+// the run's histograms.
+func (c *Collector) rotateHistograms(now time.Time) {
+	if c.histogramLog != nil && len(c.window) > 0 {
+		if err := c.histogramLog.writeWindow(c.window, c.windowStart, now); err != nil {
+			log.Printf("Failed to write histogram log: %v", err)
+		}
+	}
+	c.histograms.Merge(c.window)
+	c.window = make(HistogramSet)
+	c.windowStart = now
+}
+
+const (
+	// OutcomeSent is a call that was sent as scheduled.
+	OutcomeSent = "sent"
+	// OutcomeLate is a call that had to wait for an in-flight slot before it was sent.
+	OutcomeLate = "late"
+	// OutcomeDropped is a call that was never sent because the in-flight limit was reached.
+	OutcomeDropped = "dropped"
+	// OutcomeInterrupted marks the point at which the run was interrupted. It is not a call.
+	OutcomeInterrupted = "interrupted"
+)
+
+// noConnection is the Connection of calls that were never sent.
+const noConnection = -1
+
+// interruptMarker is the row that records when the run was interrupted. It is
+// not a call, so its status is Canceled rather than OK.
+func interruptMarker(at time.Time, elapsed time.Duration) CallResult {
+	return CallResult{
+		Timestamp:  at,
+		Status:     codes.Canceled,
+		Error:      fmt.Sprintf("run interrupted after %v", elapsed),
+		Connection: noConnection,
+		Outcome:    OutcomeInterrupted,
 	}
 }
 
 type CallResult struct {
 	Timestamp time.Time
+	// IntendedTimestamp is when the scheduler wanted the call to be sent. The gap to
+	// Timestamp is the send delay needed to correct for coordinated omission.
+	IntendedTimestamp time.Time
+	Outcome           string // OutcomeSent, OutcomeLate, OutcomeDropped or OutcomeInterrupted
 
 	Latency      time.Duration
 	Status       codes.Code
 	Error        string
 	RequestSize  int64
 	ResponseSize int64
-	// HyperFaaS-specific trailer fields
-	FunctionID                 string
-	ImageTag                   string
-	CallQueuedTimestamp        string
-	GotResponseTimestamp       string
+	FunctionID   string
+	ImageTag     string
+	Phase        string
+	// Target is the address of the Leaf that served the call and Connection the
+	// index of the connection to it in the pool, noConnection if it was never sent
+	Target     string
+	Connection int
+	// Attempts is the number of times the call was sent, more than 1 if it was retried.
+	// The other fields describe the last attempt.
+	Attempts int
+	// FirstSentTimestamp is when the first attempt was sent, zero if the call was never sent
+	FirstSentTimestamp time.Time
+	// ColdStart is set for the first call that reached InstanceID, see InstanceTracker
+	ColdStart bool
+	// HyperFaaS-specific trailer fields, zero if the trailer was missing
 	InstanceID                 string
-	LeafGotRequestTimestamp    string
-	LeafScheduledCallTimestamp string
-	FunctionProcessingTime     string
+	LeafGotRequestTimestamp    time.Time
+	LeafScheduledCallTimestamp time.Time
+	CallQueuedTimestamp        time.Time
+	GotResponseTimestamp       time.Time
+	FunctionProcessingTime     time.Duration
 }
 
+// LatencyBreakdown splits the latency of a call along the HyperFaaS trailer
+// timestamps. The parts add up to the latency. NetworkToLeaf and ResponsePath
+// compare the load generator's clock with the cluster's and include any skew
+// between them.
+type LatencyBreakdown struct {
+	NetworkToLeaf     time.Duration // from sending the call until the leaf got it
+	LeafScheduling    time.Duration // from the leaf getting the call until it scheduled it
+	Queueing          time.Duration // from scheduling until the function ran, including the way to the worker
+	FunctionExecution time.Duration // function processing time
+	ResponsePath      time.Duration // from the worker getting the response until the load generator did
+}
+
+// Breakdown derives the latency breakdown, or returns false if a trailer needed
+// for it is missing.
+func (r CallResult) Breakdown() (LatencyBreakdown, bool) {
+	if r.LeafGotRequestTimestamp.IsZero() || r.LeafScheduledCallTimestamp.IsZero() || r.GotResponseTimestamp.IsZero() || r.FunctionProcessingTime == 0 {
+		return LatencyBreakdown{}, false
+	}
+	return LatencyBreakdown{
+		NetworkToLeaf:     r.LeafGotRequestTimestamp.Sub(r.Timestamp),
+		LeafScheduling:    r.LeafScheduledCallTimestamp.Sub(r.LeafGotRequestTimestamp),
+		Queueing:          r.GotResponseTimestamp.Sub(r.LeafScheduledCallTimestamp) - r.FunctionProcessingTime,
+		FunctionExecution: r.FunctionProcessingTime,
+		ResponsePath:      r.Timestamp.Add(r.Latency).Sub(r.GotResponseTimestamp),
+	}, true
+}
+
+// SendDelay is how late the first attempt of the call was sent compared to its schedule.
+func (r CallResult) SendDelay() time.Duration {
+	if r.IntendedTimestamp.IsZero() {
+		return 0
+	}
+	if !r.FirstSentTimestamp.IsZero() {
+		return r.FirstSentTimestamp.Sub(r.IntendedTimestamp)
+	}
+	return r.Timestamp.Sub(r.IntendedTimestamp)
+}
+
+// Collect queues a result for the writer. It only blocks while the buffer is
+// full, which is counted in the backpressure stats.
 func (c *Collector) Collect(result CallResult) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	c.csvWriter.Write([]string{
-		result.Timestamp.Format(time.RFC3339),
-		result.FunctionID,
-		result.ImageTag,
-		strconv.FormatInt(result.Latency.Nanoseconds(), 10),
-		result.Status.String(),
-		result.Error,
-		strconv.FormatInt(result.RequestSize, 10),
-		strconv.FormatInt(result.ResponseSize, 10),
-		result.CallQueuedTimestamp,
-		result.GotResponseTimestamp,
-		result.InstanceID,
-		result.LeafGotRequestTimestamp,
-		result.LeafScheduledCallTimestamp,
-		result.FunctionProcessingTime,
-	})
+	// calls that outlive the drain timeout must not write to the closed sink
+	if c.closed.Load() {
+		return
+	}
+
+	select {
+	case c.results <- result:
+		return
+	default:
+	}
+
+	c.blocked.Add(1)
+	start := time.Now()
+	select {
+	case c.results <- result:
+	case <-c.done:
+	}
+	c.blockedTime.Add(int64(time.Since(start)))
 }
 
-// TODO: make this configurable
-func (c *Collector) RunFlusher() {
-	t := time.NewTicker(time.Second)
+// run is the writer goroutine. It exits once Close has been called and the
+// buffer is drained.
+func (c *Collector) run() {
+	defer close(c.done)
+	t := time.NewTicker(c.flushInterval)
 	defer t.Stop()
 
-	for range t.C {
-		c.csvWriter.Flush()
+	batch := make([]CallResult, 0, collectorBatchSize)
+	for {
+		select {
+		case result := <-c.results:
+			batch = c.fill(append(batch[:0], result))
+			c.write(batch)
+		case <-t.C:
+			if err := c.sink.Flush(); err != nil {
+				log.Printf("Failed to flush results: %v", err)
+			}
+		case <-c.stop:
+			for {
+				batch = c.fill(batch[:0])
+				if len(batch) == 0 {
+					break
+				}
+				c.write(batch)
+			}
+			if err := c.sink.Close(); err != nil {
+				log.Printf("Failed to close results file: %v", err)
+			}
+			c.histMu.Lock()
+			c.rotateHistograms(time.Now())
+			if c.histogramFile != nil {
+				c.histogramFile.Close()
+			}
+			c.histMu.Unlock()
+			return
+		}
 	}
 }
 
-func (c *Collector) Close() {
-	c.csvWriter.Flush()
-	if c.file != nil {
-		c.file.Close()
+// fill adds the results that are already buffered to batch, without waiting.
+func (c *Collector) fill(batch []CallResult) []CallResult {
+	for len(batch) < cap(batch) {
+		select {
+		case result := <-c.results:
+			batch = append(batch, result)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (c *Collector) write(batch []CallResult) {
+	for i := range batch {
+		for _, o := range c.observers {
+			if m, ok := o.(resultMarker); ok {
+				m.Mark(&batch[i])
+			}
+		}
+	}
+
+	c.histMu.Lock()
+	if now := time.Now(); now.Sub(c.windowStart) >= c.histogramInterval {
+		c.rotateHistograms(now)
 	}
+	for _, result := range batch {
+		c.window.Record(result)
+	}
+	c.histMu.Unlock()
+
+	for _, result := range batch {
+		if err := c.sink.Write(result); err != nil {
+			log.Printf("Failed to write result: %v", err)
+		}
+	}
+	c.written.Add(int64(len(batch)))
+
+	for _, result := range batch {
+		for _, o := range c.observers {
+			o.Collect(result)
+		}
+	}
+}
+
+// CollectorStats describes the backpressure on the collector.
+type CollectorStats struct {
+	Buffered    int           // results waiting for the writer
+	Capacity    int           // size of the buffer
+	Blocked     int64         // Collect calls that found the buffer full
+	BlockedTime time.Duration // total time Collect waited for a full buffer
+	Written     int64         // results written to the sink
+}
+
+func (c *Collector) Stats() CollectorStats {
+	return CollectorStats{
+		Buffered:    len(c.results),
+		Capacity:    cap(c.results),
+		Blocked:     c.blocked.Load(),
+		BlockedTime: time.Duration(c.blockedTime.Load()),
+		Written:     c.written.Load(),
+	}
+}
+
+// RunMetadata describes the run next to its results.
+type RunMetadata struct {
+	Functions []FunctionRecord `json:"functions"`
+}
+
+// WriteMetadata writes meta next to the results file, results.csv gets
+// results.meta.json. Collectors without a file, such as a worker's, skip it.
+func (c *Collector) WriteMetadata(meta RunMetadata) error {
+	if c.fileName == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := strings.TrimSuffix(c.fileName, filepath.Ext(c.fileName)) + ".meta.json"
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Close stops accepting results, waits until the writer has written the buffered
+// ones and closes the sink.
+func (c *Collector) Close() {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		close(c.stop)
+	})
+	<-c.done
 }
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollector_WritesAllResults(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "results.jsonl")
+	c := NewCollector(fileName, "", WithBufferSize(4))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				c.Collect(CallResult{Phase: "steady", Latency: time.Millisecond, Outcome: OutcomeSent})
+			}
+		}()
+	}
+	wg.Wait()
+	c.Close()
+	// ignored once closed
+	c.Collect(CallResult{Phase: "late"})
+	c.Close()
+
+	stats := c.Stats()
+	if stats.Written != 4000 || stats.Capacity != 4 {
+		t.Errorf("Expected 4000 results written through a buffer of 4, got %+v", stats)
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 4000 {
+		t.Errorf("Expected 4000 lines, got %d", lines)
+	}
+	if n := c.Histograms()[HistogramKey{Phase: "steady"}].Latency.TotalCount(); n != 4000 {
+		t.Errorf("Expected 4000 latencies in the histograms, got %d", n)
+	}
+}
+
+func TestCollector_FlushInterval(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "results.jsonl")
+	c := NewCollector(fileName, "", WithFlushInterval(10*time.Millisecond))
+	defer c.Close()
+
+	c.Collect(CallResult{Phase: "steady", Outcome: OutcomeSent})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, _ := os.ReadFile(fileName); bytes.Contains(data, []byte(`"phase":"steady"`)) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected the result to be flushed before Close")
+}
+
+func TestCollector_Backpressure(t *testing.T) {
+	c := NewCollector(filepath.Join(t.TempDir(), "results.csv"), "", WithBufferSize(1))
+	// the writer cannot keep up with a buffer of one for long
+	for i := 0; i < 10000; i++ {
+		c.Collect(CallResult{Outcome: OutcomeSent})
+	}
+	c.Close()
+	if stats := c.Stats(); stats.Blocked == 0 || stats.Written != 10000 {
+		t.Errorf("Expected blocked Collect calls and 10000 written results, got %+v", stats)
+	}
+}
+
+func TestCallResult_Breakdown(t *testing.T) {
+	start := time.Unix(100, 0)
+	result := CallResult{
+		Timestamp:                  start,
+		Latency:                    50 * time.Millisecond,
+		LeafGotRequestTimestamp:    start.Add(5 * time.Millisecond),
+		LeafScheduledCallTimestamp: start.Add(7 * time.Millisecond),
+		CallQueuedTimestamp:        start.Add(9 * time.Millisecond),
+		GotResponseTimestamp:       start.Add(40 * time.Millisecond),
+		FunctionProcessingTime:     20 * time.Millisecond,
+	}
+
+	b, ok := result.Breakdown()
+	if !ok {
+		t.Fatalf("Expected a breakdown")
+	}
+	expected := LatencyBreakdown{
+		NetworkToLeaf:     5 * time.Millisecond,
+		LeafScheduling:    2 * time.Millisecond,
+		Queueing:          13 * time.Millisecond,
+		FunctionExecution: 20 * time.Millisecond,
+		ResponsePath:      10 * time.Millisecond,
+	}
+	if b != expected {
+		t.Errorf("Expected %+v, got %+v", expected, b)
+	}
+	if sum := b.NetworkToLeaf + b.LeafScheduling + b.Queueing + b.FunctionExecution + b.ResponsePath; sum != result.Latency {
+		t.Errorf("Expected the parts to add up to %v, got %v", result.Latency, sum)
+	}
+
+	result.GotResponseTimestamp = time.Time{}
+	if _, ok := result.Breakdown(); ok {
+		t.Errorf("Expected no breakdown without the gotResponseTimestamp trailer")
+	}
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ConnectionConfig tunes the gRPC connections to the Leafs. Zero values keep the
+// gRPC defaults.
+type ConnectionConfig struct {
+	// Pool is the number of connections per Leaf, calls take turns on them. 1 if unset.
+	Pool int `yaml:"pool,omitempty"`
+	// MaxConcurrentStreams caps the calls in flight on each connection, further
+	// calls wait for a free stream. Unlimited if unset, the Leaf's own limit still applies.
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams,omitempty"`
+
+	KeepaliveTime         time.Duration `yaml:"keepalive_time,omitempty"`    // ping after this long without activity
+	KeepaliveTimeout      time.Duration `yaml:"keepalive_timeout,omitempty"` // close the connection if a ping is not answered in time
+	KeepaliveWithoutCalls bool          `yaml:"keepalive_without_calls,omitempty"`
+
+	MaxRecvMsgSize int    `yaml:"max_recv_msg_size,omitempty"` // bytes, 4MiB if unset
+	MaxSendMsgSize int    `yaml:"max_send_msg_size,omitempty"` // bytes
+	Compression    string `yaml:"compression,omitempty"`       // "none" (default) | "gzip"
+
+	InitialWindowSize     int32 `yaml:"initial_window_size,omitempty"`      // bytes per stream, at least 64KiB
+	InitialConnWindowSize int32 `yaml:"initial_conn_window_size,omitempty"` // bytes per connection, at least 64KiB
+}
+
+func (c *ConnectionConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Pool < 0 || c.MaxConcurrentStreams < 0 || c.MaxRecvMsgSize < 0 || c.MaxSendMsgSize < 0 {
+		return fmt.Errorf("connection pool, max concurrent streams and message sizes must not be negative")
+	}
+	if c.KeepaliveTime < 0 || c.KeepaliveTimeout < 0 {
+		return fmt.Errorf("keepalive time and timeout must not be negative")
+	}
+	const minWindow = 64 * 1024
+	if c.InitialWindowSize != 0 && c.InitialWindowSize < minWindow || c.InitialConnWindowSize != 0 && c.InitialConnWindowSize < minWindow {
+		return fmt.Errorf("window sizes must be at least %d bytes", minWindow)
+	}
+	switch c.Compression {
+	case "", "none", gzip.Name:
+		return nil
+	default:
+		return fmt.Errorf("compression must be none or %s, got %q", gzip.Name, c.Compression)
+	}
+}
+
+// poolSize is the number of connections per Leaf.
+func (c *ConnectionConfig) poolSize() int {
+	if c == nil || c.Pool <= 0 {
+		return 1
+	}
+	return c.Pool
+}
+
+// dialOptions turns the config into options for grpc.NewClient.
+func (c *ConnectionConfig) dialOptions(creds credentials.TransportCredentials) []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if c == nil {
+		return opts
+	}
+
+	var callOpts []grpc.CallOption
+	if c.MaxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(c.MaxRecvMsgSize))
+	}
+	if c.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(c.MaxSendMsgSize))
+	}
+	if c.Compression == gzip.Name {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	if c.KeepaliveTime > 0 || c.KeepaliveTimeout > 0 || c.KeepaliveWithoutCalls {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.KeepaliveTime,
+			Timeout:             c.KeepaliveTimeout,
+			PermitWithoutStream: c.KeepaliveWithoutCalls,
+		}))
+	}
+	if c.InitialWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(c.InitialWindowSize))
+	}
+	if c.InitialConnWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(c.InitialConnWindowSize))
+	}
+	return opts
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestConnectionConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ConnectionConfig
+		wantErr bool
+	}{
+		{"unset", nil, false},
+		{"tuned", &ConnectionConfig{Pool: 4, MaxConcurrentStreams: 100, KeepaliveTime: 30 * time.Second, Compression: "gzip", InitialWindowSize: 1 << 20}, false},
+		{"no compression", &ConnectionConfig{Compression: "none"}, false},
+		{"negative pool", &ConnectionConfig{Pool: -1}, true},
+		{"unknown compression", &ConnectionConfig{Compression: "zstd"}, true},
+		{"window below 64KiB", &ConnectionConfig{InitialConnWindowSize: 1024}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewLeafClient_Pool(t *testing.T) {
+	lc := NewLeafClient("localhost:50050", &ConnectionConfig{Pool: 3, MaxConcurrentStreams: 2}, insecure.NewCredentials())
+	defer lc.Close()
+	if len(lc.conns) != 3 {
+		t.Fatalf("Expected 3 connections, got %d", len(lc.conns))
+	}
+	if cap(lc.conns[0].streams) != 2 {
+		t.Errorf("Expected 2 streams per connection, got %d", cap(lc.conns[0].streams))
+	}
+
+	if single := NewLeafClient("localhost:50050", nil, insecure.NewCredentials()); len(single.conns) != 1 || single.conns[0].streams != nil {
+		t.Errorf("Expected a single uncapped connection by default")
+	}
+}
+
+func TestLeafConn_MaxConcurrentStreams(t *testing.T) {
+	c := &leafConn{streams: make(chan struct{}, 1)}
+	if err := c.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.acquire(ctx); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected the second call to wait until its deadline, got %v", err)
+	}
+
+	c.release()
+	if err := c.acquire(context.Background()); err != nil {
+		t.Errorf("Expected a free stream after release, got %v", err)
+	}
+}
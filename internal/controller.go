@@ -1,14 +1,18 @@
 package internal
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"google.golang.org/grpc/credentials"
 	"gopkg.in/yaml.v2"
 )
 
@@ -17,18 +21,56 @@ type Controller struct {
 	collector         *Collector
 	funcMgr           *FunctionManager
 	funcDataProviders map[string]DataProvider
+	limiter           *InFlightLimiter
+	fakeLeaf          *FakeLeaf                        // set by WithFakeLeaf
+	creds             credentials.TransportCredentials // shared by the call clients and the function manager
+	observers         []RunObserver
+	startAt           time.Time
 	l                 *slog.Logger
+
+	mu       sync.Mutex
+	running  map[LoadExecutor]string // running executors by phase name
+	stopping bool
 }
 
 type Config struct {
 	GenerateWorkload bool                       `yaml:"generate_workload"`
 	LeafAddress      string                     `yaml:"leaf_address"`
+	LeafAddresses    []string                   `yaml:"leaf_addresses,omitempty"` // several Leafs to spread the calls over, replaces leaf_address
+	LoadBalancing    string                     `yaml:"load_balancing,omitempty"` // "round_robin" (default) | "random" | "least_outstanding" | "consistent_hash"
+	Connection       *ConnectionConfig          `yaml:"connection,omitempty"`     // gRPC connection pool and channel tuning
+	TLS              *TLSConfig                 `yaml:"tls,omitempty"`            // TLS or mTLS to the Leafs, insecure if unset
+	FakeLeaf         *FakeLeafConfig            `yaml:"fake_leaf,omitempty"`      // behaviour of the fake Leaf started by --fake-leaf
 	Seed             int64                      `yaml:"seed,omitempty"`
 	MaxDuration      time.Duration              `yaml:"max_duration"`
 	Timeout          int32                      `yaml:"timeout"`
 	Patterns         map[string]*PhasePattern   `yaml:"patterns"`
 	Workload         *Workload                  `yaml:"workload,omitempty"`
 	FunctionConfig   map[string]*FunctionConfig `yaml:"function_config"`
+	Defaults         *FunctionConfig            `yaml:"defaults,omitempty"`        // function config of every image tag, overridden per field by function_config
+	MaxInFlight      int                        `yaml:"max_in_flight,omitempty"`   // global cap on concurrent calls, 0 is unlimited
+	OverloadPolicy   string                     `yaml:"overload_policy,omitempty"` // "block" (default) | "drop" | "queue"
+	QueueSize        int                        `yaml:"queue_size,omitempty"`      // calls parked by the queue policy
+	DrainTimeout     time.Duration              `yaml:"drain_timeout,omitempty"`   // wait for in-flight calls at the end of a phase, 10s if unset
+	CallTimeout      time.Duration              `yaml:"call_timeout,omitempty"`    // deadline of each call attempt, none if unset
+	Retry            *RetryPolicy               `yaml:"retry,omitempty"`           // resend failed calls, no retries if unset
+	Cleanup          string                     `yaml:"cleanup,omitempty"`         // "keep" (default) | "remove" the created functions at the end of the run
+}
+
+const (
+	CleanupKeep   = "keep"
+	CleanupRemove = "remove"
+)
+
+// cleanupTimeout bounds the removal of the functions at the end of a run.
+const cleanupTimeout = 30 * time.Second
+
+// Leafs returns the addresses of all Leafs the calls are spread over.
+func (c *Config) Leafs() []string {
+	if len(c.LeafAddresses) > 0 {
+		return c.LeafAddresses
+	}
+	return []string{c.LeafAddress}
 }
 
 type Workload struct {
@@ -40,28 +82,98 @@ type Workload struct {
 
 type TestPhase struct {
 	Name       string        `yaml:"name"`
-	Type       string        `yaml:"type"`       // "constant" | "variable"
+	Type       string        `yaml:"type"`       // "constant" | "variable" | "poisson" | "mmpp" | "spike" | "trace" | "closed"
 	StartTime  time.Duration `yaml:"start_time"` // Relative to workload start
 	Duration   time.Duration `yaml:"duration"`
 	StartRPS   int           `yaml:"start_rps"`
 	EndRPS     int           `yaml:"end_rps,omitempty"`
-	Step       int           `yaml:"step,omitempty"` // For ramping increment/decrement
+	Step       int           `yaml:"step,omitempty"`    // For ramping increment/decrement
+	Arrival    string        `yaml:"arrival,omitempty"` // "uniform" (default) | "exponential" inter-arrival times within a second
 	ImageTag   string        `yaml:"image_tag"`
-	FunctionID string        // function target
+	FunctionID string        `yaml:"function_id,omitempty"` // existing function to call, the image tag's functions if unset
+	// FunctionIDs are all functions the calls are spread over, set by CreateFunctions
+	FunctionIDs []string `yaml:"-"`
+	// Share is the part of the ramp a worker sends, set by the coordinator for ramping phases
+	Share *WorkerShare `yaml:"-"`
+
+	// Overrides of the global in-flight limit, drain timeout, call timeout and retry policy for this phase
+	MaxInFlight    int           `yaml:"max_in_flight,omitempty"`
+	OverloadPolicy string        `yaml:"overload_policy,omitempty"`
+	QueueSize      int           `yaml:"queue_size,omitempty"`
+	DrainTimeout   time.Duration `yaml:"drain_timeout,omitempty"`
+	CallTimeout    time.Duration `yaml:"call_timeout,omitempty"`
+	Retry          *RetryPolicy  `yaml:"retry,omitempty"`
+
+	// Bursty phases (mmpp, spike) use StartRPS as the baseline outside of bursts
+	BurstRPS      int           `yaml:"burst_rps,omitempty"`
+	BurstDuration time.Duration `yaml:"burst_duration,omitempty"` // mean burst length for mmpp, exact spike length for spike
+	IdleDuration  time.Duration `yaml:"idle_duration,omitempty"`  // mean time between bursts for mmpp
+	BurstInterval time.Duration `yaml:"burst_interval,omitempty"` // time between spike starts for spike
+
+	// Trace phases replay an invocation trace instead of a synthetic rate
+	TraceFile      string   `yaml:"trace_file,omitempty"`
+	TraceFunctions []string `yaml:"trace_functions,omitempty"` // function hashes replayed on image_tag, all if empty; one phase per tag to map hashes onto several tags
+	TimeScale      float64  `yaml:"time_scale,omitempty"`      // >1 compresses the trace, e.g. 60 replays a trace minute per second
+	RPSScale       float64  `yaml:"rps_scale,omitempty"`       // multiplies the number of invocations
+
+	// trace is loaded while validating the config, workers load it when the phase starts
+	trace *invocationTrace
+
+	// Closed phases run virtual users instead of a target rate. Step ramps the users every second.
+	StartUsers int           `yaml:"start_users,omitempty"`
+	EndUsers   int           `yaml:"end_users,omitempty"`
+	ThinkTime  time.Duration `yaml:"think_time,omitempty"` // pause between a response and the user's next call
+
+	// Resources of the phase's own functions, overriding the function config of the image tag field by field
+	Memory string `yaml:"memory,omitempty"`
+	Cpu    *CPU   `yaml:"cpu,omitempty"`
+	// Sweep repeats the phase for every combination of the listed resources, see ResourceSweep
+	Sweep *ResourceSweep `yaml:"sweep,omitempty"`
 }
 
-func (c *Controller) Run() {
+// Run executes the workload until all phases are done or MaxDuration is reached.
+// Cancelling ctx interrupts the run: phases that have not started are skipped,
+// running executors are stopped and drain their in-flight calls, and a marker row
+// records when the run was interrupted.
+func (c *Controller) Run(ctx context.Context) {
 
-	client := NewLeafClient(c.Config.LeafAddress)
+	client := NewLeafBalancer(c.Config.Leafs(), c.Config.LoadBalancing, c.Config.Connection, c.creds)
 	fmt.Println("Creating functions")
 	c.CreateFunctions()
 
+	c.collector.observe(c.observers)
+	results := c.collector
+	for _, o := range c.observers {
+		o.RunStarted(c.Config.Workload.Phases)
+	}
+
+	if !c.startAt.IsZero() {
+		c.l.Info("Waiting for the synchronized start", "Start", c.startAt)
+		select {
+		case <-time.After(time.Until(c.startAt)):
+		case <-ctx.Done():
+		}
+	}
+
 	fmt.Println("Starting workload, max duration:", c.Config.MaxDuration)
-	ctx, cancel := context.WithTimeout(context.Background(), c.Config.MaxDuration)
+	workloadCtx, cancel := context.WithTimeout(context.Background(), c.Config.MaxDuration)
 	defer cancel()
 
 	startTime := time.Now()
 
+	interrupted := make(chan struct{})
+	var interruptedAt time.Time
+	go func() {
+		select {
+		case <-ctx.Done():
+			interruptedAt = time.Now()
+			close(interrupted)
+			c.l.Warn("Interrupted, stopping phases and draining in-flight calls", "Elapsed", interruptedAt.Sub(startTime))
+			c.stopExecutors()
+		case <-workloadCtx.Done():
+		}
+	}()
+
 	wg := sync.WaitGroup{}
 
 	for _, phase := range c.Config.Workload.Phases {
@@ -70,45 +182,198 @@ func (c *Controller) Run() {
 			defer wg.Done()
 
 			// wait for phase start time
-			<-time.After(phase.StartTime)
+			select {
+			case <-time.After(phase.StartTime):
+			case <-interrupted:
+				c.l.Info("Skipping phase after interrupt", "Phase", phase.Name)
+				return
+			}
 			c.l.Info("Starting phase", "Phase", phase.Name, "Type", phase.Type, "Start RPS", phase.StartRPS, "End RPS", phase.EndRPS, "Step", phase.Step, "Duration", phase.Duration)
 
-			switch phase.Type {
-			case "constant":
-				executor := NewConstantExecutor(client, c.collector, c.funcMgr, c.l, c.GetDataProvider(phase.ImageTag))
-				executor.Execute(ctx, phase)
-			case "variable":
-				executor := NewRampingExecutor(client, c.collector, c.funcMgr, c.l, c.GetDataProvider(phase.ImageTag))
-				executor.Execute(ctx, phase)
+			limiter := c.limiter.ForPhase(phase.MaxInFlight, phase.OverloadPolicy, phase.QueueSize)
+			dataProvider := c.GetDataProvider(phase.ImageTag)
+
+			if phase.DrainTimeout == 0 {
+				phase.DrainTimeout = c.Config.DrainTimeout
+			}
+			if phase.CallTimeout == 0 {
+				phase.CallTimeout = c.callTimeout(phase.ImageTag)
+			}
+			if phase.Retry == nil {
+				phase.Retry = c.Config.Retry
+			}
+			// phases from WithConfig or a coordinator have not been validated here
+			newExecutor, ok := executorFor(phase.Type)
+			if !ok {
+				c.l.Error("Skipping phase of unknown type", "Phase", phase.Name, "Type", phase.Type)
+				return
+			}
+			executor := newExecutor(client, results, c.funcMgr, c.l, dataProvider, limiter)
+			if !c.track(executor, phase.Name) {
+				c.l.Info("Skipping phase after interrupt", "Phase", phase.Name)
+				return
+			}
+			for _, o := range c.observers {
+				o.PhaseStarted(phase)
+			}
+			c.execute(workloadCtx, executor, phase)
+			c.untrack(executor)
+			for _, o := range c.observers {
+				o.PhaseFinished(phase)
 			}
 
+			if limiter.Dropped() > 0 || limiter.Late() > 0 {
+				c.l.Warn("Phase overloaded the in-flight limit", "Phase", phase.Name, "Dropped", limiter.Dropped(), "Late", limiter.Late())
+			}
 		}(phase)
 	}
 
 	wg.Wait()
 
-	log.Println("Workload completed in", time.Since(startTime))
+	select {
+	case <-interrupted:
+		elapsed := interruptedAt.Sub(startTime)
+		results.Collect(interruptMarker(interruptedAt, elapsed))
+		log.Println("Workload interrupted after", elapsed, "finished in", time.Since(startTime))
+	default:
+		log.Println("Workload completed in", time.Since(startTime))
+	}
+	c.finishFunctions()
 	c.collector.Close()
 }
 
-func (c *Controller) CreateFunctions() {
-	functions := make(map[string]string)
+// track registers a running executor so an interrupt can stop it. It returns
+// false if the run is already being stopped.
+func (c *Controller) track(executor LoadExecutor, phaseName string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopping {
+		return false
+	}
+	c.running[executor] = phaseName
+	return true
+}
 
-	for _, phase := range c.Config.Workload.Phases {
-		functions[phase.ImageTag] = phase.ImageTag
+func (c *Controller) untrack(executor LoadExecutor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, executor)
+}
+
+// stopExecutors stops all running executors in parallel and waits for them to drain.
+func (c *Controller) stopExecutors() {
+	c.mu.Lock()
+	c.stopping = true
+	running := make(map[LoadExecutor]string, len(c.running))
+	maps.Copy(running, c.running)
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for executor, phaseName := range running {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			executor.Stop()
+			c.l.Info("Stopped phase", "Phase", phaseName)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *Controller) execute(ctx context.Context, executor LoadExecutor, phase TestPhase) {
+	if err := executor.Execute(ctx, phase); err != nil {
+		c.l.Error("Phase failed", "Phase", phase.Name, "Error", err)
 	}
+}
 
-	for _, imageTag := range functions {
-		f := c.funcMgr.CreateFunction(imageTag, c.Config.Workload.Timeout, c.Config.FunctionConfig[imageTag])
-		for i, phase := range c.Config.Workload.Phases {
-			if phase.ImageTag == imageTag {
-				phase.FunctionID = f.ID
-				c.Config.Workload.Phases[i] = phase
+// CreateFunctions assigns the functions of its image tag to every phase: the
+// function_ids of the tag's function config if set, else replicas newly created
+// functions. Phases with their own memory or CPU get functions of that size. The
+// calls of a phase are spread over all of them. Phases that already have a
+// function ID keep it.
+func (c *Controller) CreateFunctions() {
+	functions := make(map[string][]string)
+
+	for i, phase := range c.Config.Workload.Phases {
+		if phase.FunctionID != "" {
+			// set in the config, a worker's phases come with the functions of the coordinator
+			if len(phase.FunctionIDs) == 0 {
+				c.funcMgr.Reuse(phase.ImageTag, phase.FunctionID)
+			}
+			continue
+		}
+		// phases share functions with all phases of the same size, a phase with
+		// its own resources of the tag's size those of the tag
+		fc := c.Config.FunctionConfig[phase.ImageTag]
+		if resources := phase.resources(); resources != nil {
+			sized := resolveFunctionConfig(phase.ImageTag, fc, resources)
+			sized.FunctionIDs = nil
+			if functionKey(phase.ImageTag, sized) != functionKey(phase.ImageTag, fc) {
+				fc = sized
 			}
 		}
+		key := functionKey(phase.ImageTag, fc)
+		ids, ok := functions[key]
+		if !ok {
+			ids = c.functionsOf(phase.ImageTag, fc)
+			functions[key] = ids
+		}
+		phase.FunctionID = ids[0]
+		phase.FunctionIDs = ids
+		c.Config.Workload.Phases[i] = phase
+	}
+}
+
+// functionKey identifies the functions of an image tag by their size, in bytes
+// and CPU period and quota, so that equal sizes written differently share them.
+func functionKey(imageTag string, fc *FunctionConfig) string {
+	if fc == nil {
+		return imageTag
+	}
+	memory, _ := convertMemory(fc.Memory)
+	var cpu CPU
+	if fc.Cpu != nil {
+		cpu = *fc.Cpu
+	}
+	return fmt.Sprintf("%s %d %d/%d", imageTag, memory, cpu.Period, cpu.Quota)
+}
+
+// functionsOf reuses or creates the functions of an image tag with the given config.
+func (c *Controller) functionsOf(imageTag string, fc *FunctionConfig) []string {
+	if fc != nil && len(fc.FunctionIDs) > 0 {
+		c.funcMgr.Reuse(imageTag, fc.FunctionIDs...)
+		return fc.FunctionIDs
+	}
+	ids := make([]string, 0, fc.replicas())
+	for range fc.replicas() {
+		ids = append(ids, c.funcMgr.CreateFunction(imageTag, c.Config.Workload.Timeout, fc).ID)
+	}
+	return ids
+}
+
+// finishFunctions removes the functions the run created if the config asks for
+// it, also after an interrupt, and records the functions next to the results.
+func (c *Controller) finishFunctions() {
+	if c.Config.Cleanup == CleanupRemove {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if err := c.funcMgr.RemoveFunctions(ctx); err != nil {
+			c.l.Error("Failed to remove functions", "Error", err)
+		}
+	}
+	if err := c.collector.WriteMetadata(RunMetadata{Functions: c.funcMgr.Functions()}); err != nil {
+		c.l.Error("Failed to write run metadata", "Error", err)
 	}
 }
 
+// callTimeout is the call timeout of the image tag's function config, or the global one.
+func (c *Controller) callTimeout(imageTag string) time.Duration {
+	if fc := c.Config.FunctionConfig[imageTag]; fc != nil && fc.CallTimeout > 0 {
+		return fc.CallTimeout
+	}
+	return c.Config.CallTimeout
+}
+
 func (c *Controller) GetDataProvider(imageTag string) DataProvider {
 	return c.funcDataProviders[imageTag]
 }
@@ -117,21 +382,43 @@ type Option func(*Controller)
 
 func NewController(logger *slog.Logger, opts ...Option) *Controller {
 	c := &Controller{
-		l: logger,
+		l:       logger,
+		running: make(map[LoadExecutor]string),
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
 
 	if c.Config.GenerateWorkload {
-		generator := NewWorkloadGenerator(c.Config.Seed, c.Config.MaxDuration, c.Config.LeafAddress, c.Config.Timeout, c.Config.Patterns)
+		generator := NewWorkloadGenerator(c.Config.Seed, c.Config.MaxDuration, c.Config.Leafs()[0], c.Config.Timeout, c.Config.Patterns)
 		c.Config.Workload = generator.GenerateWorkload()
 	}
-	c.funcMgr = NewFunctionManager(c.Config.LeafAddress)
+	creds, err := c.Config.TLS.transportCredentials()
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificates: %v", err)
+	}
+	c.creds = creds
+	// functions are created through the first Leaf, the Leafs share them
+	c.funcMgr = NewFunctionManager(c.Config.Leafs()[0], c.Config.Connection, c.creds)
+	if c.fakeLeaf != nil {
+		c.funcMgr.remover = c.fakeLeaf
+	}
+	// the Leaf API has no call to remove a function, only the fake Leaf can
+	if c.Config.Cleanup == CleanupRemove && c.funcMgr.remover == nil {
+		log.Fatalf("Cleanup %s needs the fake Leaf, the Leafs cannot remove functions", CleanupRemove)
+	}
+	c.limiter = NewInFlightLimiter(c.Config.MaxInFlight, c.Config.OverloadPolicy, c.Config.QueueSize)
 	c.funcDataProviders = make(map[string]DataProvider)
 
 	distinctImageTags := getDistinctImageTags(c.Config.Workload.Phases)
 
+	// every image tag gets a complete function config, generated workloads may use tags the config does not mention
+	functionConfigs := make(map[string]*FunctionConfig, len(distinctImageTags))
+	for _, imageTag := range distinctImageTags {
+		functionConfigs[imageTag] = resolveFunctionConfig(imageTag, c.Config.Defaults, c.Config.FunctionConfig[imageTag])
+	}
+	c.Config.FunctionConfig = functionConfigs
+
 	for _, imageTag := range distinctImageTags {
 		switch imageTag {
 		case "hyperfaas-echo:latest":
@@ -140,6 +427,9 @@ func NewController(logger *slog.Logger, opts ...Option) *Controller {
 			c.funcDataProviders[imageTag] = NewBFSJSONDataProvider(100, 250)
 		case "hyperfaas-thumbnailer-json:latest":
 			c.funcDataProviders[imageTag] = NewThumbnailerJSONDataProvider()
+		default:
+			// other functions get random bytes like the echo function
+			c.funcDataProviders[imageTag] = NewEchoDataProvider(256, 1024)
 		}
 	}
 
@@ -158,14 +448,63 @@ func WithConfigFile(path string) Option {
 			log.Fatal("Failed to parse config file:", err)
 		}
 
-		if c.Config.LeafAddress == "" {
+		if c.Config.LeafAddress == "" && len(c.Config.LeafAddresses) == 0 {
 			log.Fatal("Leaf address is required")
 		}
+		if c.Config.LeafAddress != "" && len(c.Config.LeafAddresses) > 0 {
+			log.Fatal("Set either leaf_address or leaf_addresses, not both")
+		}
+		if err := validateLoadBalancing(c.Config.LoadBalancing); err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Config.Connection.validate(); err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Config.TLS.validate(); err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Config.FakeLeaf.validate(); err != nil {
+			log.Fatal(err)
+		}
 
 		if c.Config.GenerateWorkload && c.Config.Patterns == nil {
 			log.Fatal("Generate workload is true, but no patterns are provided")
 		}
 
+		for name, pattern := range c.Config.Patterns {
+			params := pattern.Parameters
+			if pattern.MMPPLikelihood > 0 && (params.BurstRPS.Max == 0 || params.BurstDuration.Min <= 0 || params.IdleDuration.Min <= 0) {
+				log.Fatalf("Pattern %s: burst_rps, burst_duration and idle_duration are required for mmpp phases", name)
+			}
+			if pattern.SpikeLikelihood > 0 && (params.BurstRPS.Max == 0 || params.BurstDuration.Min <= 0 || params.BurstInterval.Min <= params.BurstDuration.Max) {
+				log.Fatalf("Pattern %s: burst_rps, burst_duration and a burst_interval longer than the burst duration are required for spike phases", name)
+			}
+		}
+
+		if err := validateOverloadPolicy(c.Config.OverloadPolicy, c.Config.QueueSize); err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Config.Retry.validate(); err != nil {
+			log.Fatal(err)
+		}
+		for imageTag, fc := range c.Config.FunctionConfig {
+			if err := fc.validate(); err != nil {
+				log.Fatalf("Function config %s: %v", imageTag, err)
+			}
+		}
+		if err := c.Config.Defaults.validate(); err != nil {
+			log.Fatalf("Defaults: %v", err)
+		}
+		if c.Config.Defaults != nil && len(c.Config.Defaults.FunctionIDs) > 0 {
+			log.Fatal("Defaults: function_ids can only be set per image tag")
+		}
+		if c.Config.Cleanup != "" && c.Config.Cleanup != CleanupKeep && c.Config.Cleanup != CleanupRemove {
+			log.Fatalf("Cleanup must be %s or %s, got %q", CleanupKeep, CleanupRemove, c.Config.Cleanup)
+		}
+		if c.Config.CallTimeout < 0 {
+			log.Fatal("Call timeout must not be negative")
+		}
+
 		if c.Config.MaxDuration == 0 {
 			log.Fatal("Max duration is required")
 		}
@@ -176,8 +515,33 @@ func WithConfigFile(path string) Option {
 
 		if c.Config.Workload != nil {
 			for _, phase := range c.Config.Workload.Phases {
-				if phase.Type != "constant" && phase.Type != "variable" {
-					log.Fatal("Phase type must be either constant or variable")
+				if phase.Sweep == nil {
+					continue
+				}
+				for _, step := range phase.Sweep.steps() {
+					if err := step.validate(); err != nil {
+						log.Fatalf("Phase %s: sweep: %v", phase.Name, err)
+					}
+				}
+				if phase.Sweep.Pause < 0 {
+					log.Fatalf("Phase %s: sweep pause must not be negative", phase.Name)
+				}
+				steps := time.Duration(len(phase.Sweep.steps()))
+				if end := phase.StartTime + steps*(phase.Duration+phase.Sweep.Pause) - phase.Sweep.Pause; end > c.Config.MaxDuration {
+					log.Fatalf("Phase %s: the sweep ends at %v, after max_duration %v", phase.Name, end, c.Config.MaxDuration)
+				}
+			}
+			c.Config.Workload.Phases = expandSweeps(c.Config.Workload.Phases)
+
+			for i, phase := range c.Config.Workload.Phases {
+				if err := phase.resources().validate(); err != nil {
+					log.Fatalf("Phase %s: %v", phase.Name, err)
+				}
+				if _, ok := executorFor(phase.Type); !ok {
+					log.Fatalf("Phase type must be one of %s, got %q", strings.Join(PhaseTypes(), ", "), phase.Type)
+				}
+				if phase.Duration <= 0 {
+					log.Fatalf("Phase %s: duration must be positive, got %v", phase.Name, phase.Duration)
 				}
 				if phase.Type == "variable" && (phase.EndRPS == 0 || phase.Step == 0) {
 					log.Fatal("Step and end RPS are required for variable phases")
@@ -185,17 +549,97 @@ func WithConfigFile(path string) Option {
 				if phase.Type == "constant" && (phase.StartRPS == 0 || phase.EndRPS != 0 || phase.Step != 0) {
 					log.Fatal("Start RPS is required for constant phases")
 				}
+				if phase.Type == "poisson" && phase.StartRPS == 0 {
+					log.Fatal("Start RPS is required for poisson phases")
+				}
+				if phase.Type == "mmpp" && (phase.BurstRPS == 0 || phase.BurstDuration == 0 || phase.IdleDuration == 0) {
+					log.Fatal("Burst RPS, burst duration and idle duration are required for mmpp phases")
+				}
+				if phase.Type == "spike" && (phase.BurstRPS == 0 || phase.BurstDuration == 0 || phase.BurstInterval <= phase.BurstDuration) {
+					log.Fatal("Burst RPS, burst duration and a burst interval longer than the burst duration are required for spike phases")
+				}
+				if phase.Type == "trace" && phase.TraceFile == "" {
+					log.Fatal("Trace file is required for trace phases")
+				}
+				if phase.Type == "trace" {
+					// fail before any function is created or phase has run
+					trace, err := loadTrace(phase.TraceFile, phase.TraceFunctions)
+					if err != nil {
+						log.Fatalf("Phase %s: failed to load trace %s: %v", phase.Name, phase.TraceFile, err)
+					}
+					if trace.empty() {
+						log.Fatalf("Phase %s: trace %s has no invocations of the selected functions", phase.Name, phase.TraceFile)
+					}
+					c.Config.Workload.Phases[i].trace = trace
+				}
+				if phase.Type == "closed" && (phase.StartUsers == 0 || phase.Step != 0 && phase.EndUsers == 0) {
+					log.Fatal("Start users are required for closed phases, and end users when ramping with step")
+				}
+				if phase.TimeScale < 0 || phase.RPSScale < 0 {
+					log.Fatal("Time scale and RPS scale must not be negative")
+				}
+				// a phase without its own queue size uses the global one
+				if err := validateOverloadPolicy(phase.OverloadPolicy, cmp.Or(phase.QueueSize, c.Config.QueueSize)); err != nil {
+					log.Fatalf("Phase %s: %v", phase.Name, err)
+				}
+				if err := validateArrival(phase.Arrival); err != nil {
+					log.Fatalf("Phase %s: %v", phase.Name, err)
+				}
+				if err := phase.Retry.validate(); err != nil {
+					log.Fatalf("Phase %s: %v", phase.Name, err)
+				}
+				if phase.CallTimeout < 0 {
+					log.Fatalf("Phase %s: call timeout must not be negative", phase.Name)
+				}
 			}
 		}
 	}
 }
 
+// WithFakeLeaf starts an in-process fake Leaf as described by the fake_leaf
+// section of the config and sends all calls to it. It must follow WithConfigFile.
+func WithFakeLeaf() Option {
+	return func(c *Controller) {
+		c.fakeLeaf = NewFakeLeaf(c.Config.FakeLeaf, c.l)
+		address, err := c.fakeLeaf.Start()
+		if err != nil {
+			log.Fatalf("Failed to start fake Leaf: %v", err)
+		}
+		c.Config.LeafAddress = address
+		c.Config.LeafAddresses = nil
+		c.Config.TLS = nil
+	}
+}
+
+// WithConfig uses a config that was already loaded and validated, such as the
+// share of the workload a worker receives from its coordinator.
+func WithConfig(config *Config) Option {
+	return func(c *Controller) {
+		c.Config = config
+	}
+}
+
+// WithStartAt holds back the start of the workload until t, after the functions
+// were created, so that several load generators start at the same time.
+func WithStartAt(t time.Time) Option {
+	return func(c *Controller) {
+		c.startAt = t
+	}
+}
+
 func WithCollector(collector *Collector) Option {
 	return func(c *Controller) {
 		c.collector = collector
 	}
 }
 
+// WithObserver adds an observer that follows the run next to the collector.
+func WithObserver(observer RunObserver) Option {
+	return func(c *Controller) {
+		c.observers = append(c.observers, observer)
+	}
+}
+
 func getDistinctImageTags(phases []TestPhase) []string {
 	imageTags := make(map[string]bool)
 	for _, phase := range phases {
@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// maxWindowSamples caps the latency samples kept per row so that very high rates
+// cannot grow the dashboard without bound.
+const maxWindowSamples = 100_000
+
+// Dashboard is a RunObserver that redraws a live overview of the run in the
+// terminal: per phase and per image tag target and achieved RPS, in-flight calls,
+// latency percentiles over a sliding window and errors by status code.
+type Dashboard struct {
+	out    io.Writer
+	window time.Duration
+	// calls in flight by phase and by image tag, counted without the lock
+	inFlightByPhase callCounters
+	inFlightByTag   callCounters
+
+	mu       sync.Mutex
+	start    time.Time
+	phases   []TestPhase
+	started  map[string]time.Time
+	finished map[string]bool
+	byPhase  map[string]*liveStats
+	byTag    map[string]*liveStats
+}
+
+func NewDashboard(out io.Writer, window time.Duration) *Dashboard {
+	return &Dashboard{
+		out:      out,
+		window:   window,
+		started:  make(map[string]time.Time),
+		finished: make(map[string]bool),
+		byPhase:  make(map[string]*liveStats),
+		byTag:    make(map[string]*liveStats),
+	}
+}
+
+// Run redraws the dashboard every second until ctx is done, then draws it a
+// last time.
+func (d *Dashboard) Run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.Render(time.Now())
+			return
+		case <-t.C:
+			d.Render(time.Now())
+		}
+	}
+}
+
+func (d *Dashboard) RunStarted(phases []TestPhase) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.start = time.Now()
+	d.phases = phases
+}
+
+func (d *Dashboard) PhaseStarted(phase TestPhase) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.started[phase.Name] = time.Now()
+}
+
+func (d *Dashboard) PhaseFinished(phase TestPhase) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.finished[phase.Name] = true
+}
+
+func (d *Dashboard) CallStarted(phase TestPhase) {
+	d.inFlightByPhase.get(phase.Name).Add(1)
+	d.inFlightByTag.get(phase.ImageTag).Add(1)
+}
+
+func (d *Dashboard) Collect(result CallResult) {
+	if result.Outcome == OutcomeInterrupted {
+		return
+	}
+	if result.Outcome != OutcomeDropped {
+		d.inFlightByPhase.get(result.Phase).Add(-1)
+		d.inFlightByTag.get(result.ImageTag).Add(-1)
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range []*liveStats{d.stats(d.byPhase, result.Phase), d.stats(d.byTag, result.ImageTag)} {
+		if result.Outcome != OutcomeDropped {
+			// counted by the second the call was sent in
+			if !result.Timestamp.IsZero() {
+				s.sent.add(result.Timestamp)
+			}
+			s.addLatency(now, result.Latency, d.window)
+		}
+		if result.Status != codes.OK {
+			s.errors[result.Status]++
+		}
+	}
+}
+
+func (d *Dashboard) stats(m map[string]*liveStats, key string) *liveStats {
+	s, ok := m[key]
+	if !ok {
+		s = &liveStats{errors: make(map[codes.Code]int64)}
+		m[key] = s
+	}
+	return s
+}
+
+// liveRow is a copy of the numbers of one dashboard row, taken under the lock so
+// that the sorting and drawing do not hold back the calls.
+type liveRow struct {
+	name, phaseType, imageTag string
+	progress, target          string
+	rps                       float64
+	inFlight                  int64
+	latencies                 []time.Duration
+	errors                    string
+}
+
+func (d *Dashboard) snapshot(now time.Time) (elapsed time.Duration, phases, tags []liveRow) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.start.IsZero() {
+		elapsed = now.Sub(d.start).Truncate(time.Second)
+	}
+	for _, phase := range d.phases {
+		target := "-"
+		progress := "pending"
+		if started, ok := d.started[phase.Name]; ok {
+			phaseElapsed := now.Sub(started)
+			if rps, ok := TargetRPS(phase, phaseElapsed); ok && !d.finished[phase.Name] {
+				target = fmt.Sprintf("%.0f", rps)
+			}
+			progress = progressBar(phaseElapsed, phase.Duration)
+		}
+		if d.finished[phase.Name] {
+			progress = "done"
+		}
+		row := d.stats(d.byPhase, phase.Name).row(now, d.window)
+		row.inFlight = d.inFlightByPhase.get(phase.Name).Load()
+		row.name, row.phaseType, row.imageTag, row.progress, row.target = phase.Name, phase.Type, phase.ImageTag, progress, target
+		phases = append(phases, row)
+	}
+	for _, tag := range slices.Sorted(maps.Keys(d.byTag)) {
+		row := d.byTag[tag].row(now, d.window)
+		row.inFlight = d.inFlightByTag.get(tag).Load()
+		row.imageTag = tag
+		tags = append(tags, row)
+	}
+	return elapsed, phases, tags
+}
+
+// Render draws the current state, as of now, over the previous frame.
+func (d *Dashboard) Render(now time.Time) {
+	elapsed, phases, tags := d.snapshot(now)
+
+	var b strings.Builder
+	// move the cursor home and clear the screen
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "HyperFaaS load generator - elapsed %v, latency window %v\n\n", elapsed, d.window)
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tTYPE\tIMAGE TAG\tPROGRESS\tTARGET RPS\tACHIEVED RPS\tIN-FLIGHT\tP50\tP95\tP99\tERRORS")
+	for _, row := range phases {
+		p50, p95, p99 := percentiles(row.latencies)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.1f\t%d\t%v\t%v\t%v\t%s\n",
+			row.name, row.phaseType, row.imageTag, row.progress, row.target, row.rps, row.inFlight, p50, p95, p99, row.errors)
+	}
+	w.Flush()
+
+	b.WriteString("\n")
+	w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE TAG\tACHIEVED RPS\tIN-FLIGHT\tP50\tP95\tP99\tERRORS")
+	for _, row := range tags {
+		p50, p95, p99 := percentiles(row.latencies)
+		fmt.Fprintf(w, "%s\t%.1f\t%d\t%v\t%v\t%v\t%s\n", row.imageTag, row.rps, row.inFlight, p50, p95, p99, row.errors)
+	}
+	w.Flush()
+
+	io.WriteString(d.out, b.String())
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// liveStats holds the dashboard numbers of one phase or image tag.
+type liveStats struct {
+	sent    secondCounter
+	samples []latencySample // ordered by completion time
+	errors  map[codes.Code]int64
+}
+
+func (s *liveStats) addLatency(now time.Time, latency time.Duration, window time.Duration) {
+	s.samples = append(s.samples, latencySample{at: now, latency: latency})
+	s.trim(now, window)
+}
+
+func (s *liveStats) trim(now time.Time, window time.Duration) {
+	drop := 0
+	for drop < len(s.samples) && now.Sub(s.samples[drop].at) > window {
+		drop++
+	}
+	drop = max(drop, len(s.samples)-maxWindowSamples)
+	// the dropped samples are freed when append next grows the slice
+	s.samples = s.samples[drop:]
+}
+
+// row copies the numbers of s, with the latencies of the window, into a row.
+func (s *liveStats) row(now time.Time, window time.Duration) liveRow {
+	s.trim(now, window)
+	latencies := make([]time.Duration, len(s.samples))
+	for i, sample := range s.samples {
+		latencies[i] = sample.latency
+	}
+	return liveRow{
+		rps:       s.sent.rate(now, window),
+		latencies: latencies,
+		errors:    formatErrors(s.errors),
+	}
+}
+
+// percentiles sorts latencies and returns their p50, p95 and p99.
+func percentiles(latencies []time.Duration) (p50, p95, p99 time.Duration) {
+	if len(latencies) == 0 {
+		return 0, 0, 0
+	}
+	slices.Sort(latencies)
+	return percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99)
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	rank = min(max(rank, 0), len(sorted)-1)
+	return sorted[rank].Round(time.Microsecond)
+}
+
+// secondCounter counts events in one-second buckets for the last minute.
+type secondCounter struct {
+	seconds [60]int64
+	counts  [60]int64
+}
+
+func (c *secondCounter) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % int64(len(c.counts))
+	if c.seconds[i] > sec {
+		// older than the last minute
+		return
+	}
+	if c.seconds[i] != sec {
+		c.seconds[i] = sec
+		c.counts[i] = 0
+	}
+	c.counts[i]++
+}
+
+// rate returns the events per second over the complete seconds of window before now.
+func (c *secondCounter) rate(now time.Time, window time.Duration) float64 {
+	n := min(max(int64(window/time.Second), 1), int64(len(c.counts)-1))
+	current := now.Unix()
+	var total int64
+	for sec := current - n; sec < current; sec++ {
+		i := sec % int64(len(c.counts))
+		if c.seconds[i] == sec {
+			total += c.counts[i]
+		}
+	}
+	return float64(total) / float64(n)
+}
+
+func progressBar(elapsed, duration time.Duration) string {
+	const width = 20
+	if duration <= 0 {
+		return ""
+	}
+	ratio := min(max(float64(elapsed)/float64(duration), 0), 1)
+	filled := int(ratio * width)
+	return fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("#", filled), strings.Repeat(".", width-filled), ratio*100)
+}
+
+func formatErrors(errors map[codes.Code]int64) string {
+	if len(errors) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(errors))
+	for _, code := range slices.Sorted(maps.Keys(errors)) {
+		parts = append(parts, fmt.Sprintf("%s=%d", code, errors[code]))
+	}
+	return strings.Join(parts, " ")
+}
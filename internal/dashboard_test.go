@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestDashboard_Render(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDashboard(&out, 10*time.Second)
+	phases := []TestPhase{
+		{Name: "warmup", Type: "constant", StartRPS: 10, Duration: time.Minute, ImageTag: "echo:latest"},
+		{Name: "later", Type: "variable", StartRPS: 1, EndRPS: 5, Step: 1, Duration: time.Minute, ImageTag: "bfs:latest"},
+	}
+	d.RunStarted(phases)
+	d.PhaseStarted(phases[0])
+
+	for i := 0; i < 4; i++ {
+		d.CallStarted(phases[0])
+	}
+	d.Collect(CallResult{Phase: "warmup", ImageTag: "echo:latest", Latency: 10 * time.Millisecond})
+	d.Collect(CallResult{Phase: "warmup", ImageTag: "echo:latest", Latency: 30 * time.Millisecond, Status: codes.Unavailable})
+
+	d.Render(time.Now())
+	frame := out.String()
+
+	for _, expected := range []string{"warmup", "later", "pending", "echo:latest", "Unavailable=1", "30ms"} {
+		if !strings.Contains(frame, expected) {
+			t.Errorf("Expected frame to contain %q:\n%s", expected, frame)
+		}
+	}
+
+	// two of the four calls are still in flight
+	for _, line := range strings.Split(frame, "\n") {
+		if strings.HasPrefix(line, "warmup") && !strings.Contains(line, " 2 ") {
+			t.Errorf("Expected 2 calls in flight for warmup, got line %q", line)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{95, 95 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+		{0, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.expected {
+			t.Errorf("percentile(%v) = %v, expected %v", tt.p, got, tt.expected)
+		}
+	}
+}
+
+func TestSecondCounter_Rate(t *testing.T) {
+	var c secondCounter
+	now := time.Unix(1000, 0)
+	for sec := int64(990); sec < 1000; sec++ {
+		for i := 0; i < 5; i++ {
+			c.add(time.Unix(sec, 0))
+		}
+	}
+	// events in the current, incomplete second are not counted
+	c.add(now)
+
+	if rate := c.rate(now, 10*time.Second); rate != 5 {
+		t.Errorf("Expected 5 RPS, got %v", rate)
+	}
+}
@@ -0,0 +1,540 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// jsonCodecName is the content subtype of the worker service. Its messages are
+	// plain Go structs encoded as JSON, so no generated protobuf code is needed.
+	jsonCodecName = "json"
+
+	workerRunMethod       = "/lg.Worker/Run"
+	workerInterruptMethod = "/lg.Worker/Interrupt"
+
+	// workerBatchSize is the most results a worker sends in one message.
+	workerBatchSize = 1024
+	// workerFlushInterval is how often a worker sends the results it has so far.
+	workerFlushInterval = 200 * time.Millisecond
+	// DefaultStartDelay gives the workers time to receive their share before the
+	// synchronized start.
+	DefaultStartDelay = 3 * time.Second
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return jsonCodecName }
+
+// workerRunRequest hands a worker its share of the workload. The functions were
+// created by the coordinator, so every phase carries its function ID.
+type workerRunRequest struct {
+	Config  *Config   `json:"config"`
+	StartAt time.Time `json:"start_at"`
+	Worker  int       `json:"worker"`
+	Workers int       `json:"workers"`
+}
+
+// workerMessage is streamed back by a worker: a batch of results or a phase event.
+type workerMessage struct {
+	Results       []CallResult `json:"results,omitempty"`
+	PhaseStarted  string       `json:"phase_started,omitempty"`
+	PhaseFinished string       `json:"phase_finished,omitempty"`
+}
+
+type workerInterruptRequest struct{}
+type workerInterruptResponse struct{}
+
+// workerService is implemented by Worker, grpc checks handlers against it.
+type workerService interface {
+	run(req *workerRunRequest, stream grpc.ServerStream) error
+	interrupt()
+}
+
+var workerServiceDesc = grpc.ServiceDesc{
+	ServiceName: "lg.Worker",
+	HandlerType: (*workerService)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Interrupt",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			if err := dec(&workerInterruptRequest{}); err != nil {
+				return nil, err
+			}
+			srv.(workerService).interrupt()
+			return &workerInterruptResponse{}, nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Run",
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			req := &workerRunRequest{}
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(workerService).run(req, stream)
+		},
+		ServerStreams: true,
+	}},
+}
+
+// Worker runs the share of a workload it receives from a coordinator and streams
+// the results back. It runs one workload at a time.
+type Worker struct {
+	l *slog.Logger
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+func NewWorker(l *slog.Logger) *Worker {
+	return &Worker{l: l}
+}
+
+// Serve accepts coordinators on lis until it fails.
+func (w *Worker) Serve(lis net.Listener) error {
+	server := grpc.NewServer()
+	server.RegisterService(&workerServiceDesc, w)
+	w.l.Info("Worker waiting for a coordinator", "Address", lis.Addr())
+	return server.Serve(lis)
+}
+
+func (w *Worker) run(req *workerRunRequest, stream grpc.ServerStream) error {
+	if req.Config == nil || req.Config.Workload == nil {
+		return status.Error(codes.InvalidArgument, "run request without a workload")
+	}
+	// the retry policies arrive without their parsed status codes
+	if err := req.Config.Retry.validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, phase := range req.Config.Workload.Phases {
+		if err := phase.Retry.validate(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "phase %s: %v", phase.Name, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	w.mu.Lock()
+	if w.cancel != nil {
+		w.mu.Unlock()
+		return status.Error(codes.FailedPrecondition, "worker is already running a workload")
+	}
+	w.cancel = cancel
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.cancel = nil
+		w.mu.Unlock()
+	}()
+
+	l := w.l.With("Worker", fmt.Sprintf("%d/%d", req.Worker+1, req.Workers))
+	if late := time.Since(req.StartAt); late > 0 {
+		l.Warn("Start time already passed, check the clocks of coordinator and worker", "Late", late)
+	}
+	l.Info("Received workload", "Phases", len(req.Config.Workload.Phases), "Start", req.StartAt)
+
+	out := &workerStream{stream: stream}
+	collector := NewCollectorForSink(out, WithFlushInterval(workerFlushInterval))
+	controller := NewController(l,
+		WithConfig(req.Config),
+		WithCollector(collector),
+		WithObserver(out),
+		WithStartAt(req.StartAt),
+	)
+	controller.Run(ctx)
+	return out.err()
+}
+
+func (w *Worker) interrupt() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		w.l.Warn("Interrupted by the coordinator")
+		w.cancel()
+	}
+}
+
+// workerStream is the Sink of a worker's collector and sends the results to the
+// coordinator in batches. As an observer it sends the phase events, after the
+// results collected so far.
+type workerStream struct {
+	stream grpc.ServerStream
+
+	mu     sync.Mutex
+	batch  []CallResult
+	failed error
+}
+
+func (s *workerStream) Write(result CallResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch = append(s.batch, result)
+	if len(s.batch) >= workerBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *workerStream) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *workerStream) Close() error {
+	return s.Flush()
+}
+
+func (s *workerStream) flush() error {
+	if len(s.batch) == 0 {
+		return s.failed
+	}
+	err := s.send(&workerMessage{Results: s.batch})
+	s.batch = s.batch[:0]
+	return err
+}
+
+func (s *workerStream) send(msg *workerMessage) error {
+	if s.failed != nil {
+		return s.failed
+	}
+	if err := s.stream.SendMsg(msg); err != nil {
+		s.failed = err
+	}
+	return s.failed
+}
+
+func (s *workerStream) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+func (s *workerStream) RunStarted(phases []TestPhase) {}
+func (s *workerStream) CallStarted(phase TestPhase)   {}
+func (s *workerStream) Collect(result CallResult)     {}
+
+func (s *workerStream) PhaseStarted(phase TestPhase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+	s.send(&workerMessage{PhaseStarted: phase.Name})
+}
+
+func (s *workerStream) PhaseFinished(phase TestPhase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+	s.send(&workerMessage{PhaseFinished: phase.Name})
+}
+
+// Coordinator runs the workload of a controller on remote workers. It creates
+// the functions, splits every phase across the workers, starts them at the same
+// time and merges the results they stream back into the controller's collector
+// and observers.
+type Coordinator struct {
+	controller *Controller
+	workers    []string
+	startDelay time.Duration
+}
+
+// NewCoordinator sends the workload to the workers at the given addresses. They
+// start startDelay after the functions were created.
+func NewCoordinator(controller *Controller, workers []string, startDelay time.Duration) *Coordinator {
+	if startDelay <= 0 {
+		startDelay = DefaultStartDelay
+	}
+	return &Coordinator{controller: controller, workers: workers, startDelay: startDelay}
+}
+
+// Run executes the workload on the workers until all of them are done.
+// Cancelling ctx interrupts every worker, which then drain their in-flight calls
+// and send the remaining results. It returns an error if a worker could not be
+// started or failed during the run, after the results were written.
+func (co *Coordinator) Run(ctx context.Context) error {
+	c := co.controller
+	fmt.Println("Creating functions")
+	c.CreateFunctions()
+
+	// the streams outlive ctx so that the workers can send what they drained after an interrupt
+	streamCtx, cancelStreams := context.WithCancel(context.Background())
+	defer cancelStreams()
+	abort := func(err error) error {
+		// the workers that already received their share stop once their stream is cancelled
+		cancelStreams()
+		c.finishFunctions()
+		c.collector.Close()
+		return err
+	}
+
+	startAt := time.Now().Add(co.startDelay)
+	conns := make([]*grpc.ClientConn, len(co.workers))
+	streams := make([]grpc.ClientStream, len(co.workers))
+	configs := make([]*Config, len(co.workers))
+	running := make(map[string]int) // number of workers running each phase
+	for i, addr := range co.workers {
+		conn, err := grpc.NewClient(addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodecName)),
+		)
+		if err != nil {
+			return abort(fmt.Errorf("failed to connect to worker %s: %w", addr, err))
+		}
+		defer conn.Close()
+		conns[i] = conn
+
+		config := splitConfig(c.Config, i, len(co.workers))
+		stream, err := conn.NewStream(streamCtx, &workerServiceDesc.Streams[0], workerRunMethod)
+		if err == nil {
+			err = stream.SendMsg(&workerRunRequest{Config: config, StartAt: startAt, Worker: i, Workers: len(co.workers)})
+		}
+		if err == nil {
+			err = stream.CloseSend()
+		}
+		if err != nil {
+			return abort(fmt.Errorf("failed to start worker %s: %w", addr, err))
+		}
+		streams[i] = stream
+		configs[i] = config
+		for _, phase := range config.Workload.Phases {
+			running[phase.Name]++
+		}
+		c.l.Info("Started worker", "Worker", addr, "Phases", len(config.Workload.Phases))
+	}
+
+	phases := make(map[string]TestPhase, len(c.Config.Workload.Phases))
+	for _, phase := range c.Config.Workload.Phases {
+		phases[phase.Name] = phase
+	}
+	c.collector.observe(c.observers)
+	results := c.collector
+	for _, o := range c.observers {
+		o.RunStarted(c.Config.Workload.Phases)
+	}
+	fmt.Println("Starting workload on", len(co.workers), "workers at", startAt.Format(time.RFC3339Nano), "max duration:", c.Config.MaxDuration)
+
+	done := make(chan struct{})
+	interrupted := make(chan struct{})
+	var interruptedAt time.Time
+	go func() {
+		select {
+		case <-ctx.Done():
+			interruptedAt = time.Now()
+			close(interrupted)
+			c.l.Warn("Interrupted, stopping workers and draining in-flight calls", "Elapsed", interruptedAt.Sub(startAt))
+			co.interrupt(conns)
+		case <-done:
+		}
+	}()
+
+	// phase events are passed on when the first worker starts a phase and the last one finishes it
+	var mu sync.Mutex
+	started := make(map[string]bool)
+	var failures []string
+	finish := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if running[name] == 0 {
+			return
+		}
+		running[name]--
+		if running[name] == 0 && started[name] {
+			for _, o := range c.observers {
+				o.PhaseFinished(phases[name])
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr := co.workers[i]
+			pending := make(map[string]bool) // phases of this worker that have not finished
+			for _, phase := range configs[i].Workload.Phases {
+				pending[phase.Name] = true
+			}
+			defer func() {
+				// a failed worker does not hold back the phases it was part of
+				for name := range pending {
+					finish(name)
+				}
+			}()
+			for {
+				var msg workerMessage
+				if err := stream.RecvMsg(&msg); err != nil {
+					if !errors.Is(err, io.EOF) {
+						c.l.Error("Worker failed", "Worker", addr, "Error", err)
+						mu.Lock()
+						failures = append(failures, fmt.Sprintf("worker %s failed: %v", addr, err))
+						mu.Unlock()
+					}
+					return
+				}
+				for _, result := range msg.Results {
+					// the coordinator records the interrupt itself
+					if result.Outcome == OutcomeInterrupted {
+						continue
+					}
+					if result.Outcome != OutcomeDropped {
+						results.CallStarted(phases[result.Phase])
+					}
+					results.Collect(result)
+				}
+				if name := msg.PhaseStarted; name != "" {
+					mu.Lock()
+					if !started[name] {
+						started[name] = true
+						for _, o := range c.observers {
+							o.PhaseStarted(phases[name])
+						}
+					}
+					mu.Unlock()
+				}
+				if name := msg.PhaseFinished; name != "" {
+					delete(pending, name)
+					finish(name)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+
+	select {
+	case <-interrupted:
+		elapsed := interruptedAt.Sub(startAt)
+		results.Collect(interruptMarker(interruptedAt, elapsed))
+		log.Println("Workload interrupted after", elapsed, "finished in", time.Since(startAt))
+	default:
+		if len(failures) == 0 {
+			log.Println("Workload completed in", time.Since(startAt))
+		}
+	}
+	c.finishFunctions()
+	c.collector.Close()
+
+	if len(failures) > 0 {
+		// part of the load is missing from the results
+		for _, o := range c.observers {
+			if f, ok := o.(runFailer); ok {
+				f.RunFailed(failures)
+			}
+		}
+		return fmt.Errorf("%d of %d workers failed: %s", len(failures), len(co.workers), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// interrupt asks every worker to stop its phases.
+func (co *Coordinator) interrupt(conns []*grpc.ClientConn) {
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := conn.Invoke(ctx, workerInterruptMethod, &workerInterruptRequest{}, &workerInterruptResponse{}); err != nil {
+				co.controller.l.Error("Failed to interrupt worker", "Worker", co.workers[i], "Error", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// splitConfig returns the share of worker (counted from 0) out of workers. Rates,
+// users and in-flight limits are divided, with the remainder going to the first
+// workers; phases that leave nothing for this worker are left out.
+func splitConfig(config *Config, worker, workers int) *Config {
+	split := *config
+	split.GenerateWorkload = false
+	split.Patterns = nil
+	split.MaxInFlight = splitLimit(config.MaxInFlight, worker, workers)
+	split.QueueSize = splitLimit(config.QueueSize, worker, workers)
+
+	workload := *config.Workload
+	workload.Phases = nil
+	for _, phase := range config.Workload.Phases {
+		if phase, ok := splitPhase(phase, worker, workers); ok {
+			workload.Phases = append(workload.Phases, phase)
+		}
+	}
+	split.Workload = &workload
+	return &split
+}
+
+// WorkerShare names a worker's part of a split phase.
+type WorkerShare struct {
+	Worker  int
+	Workers int
+}
+
+func splitPhase(phase TestPhase, worker, workers int) (TestPhase, bool) {
+	if phase.Step != 0 {
+		// ramps keep their whole rate or users and step, so that every worker
+		// reaches the end at the same time, and send their share of each second
+		phase.Share = &WorkerShare{Worker: worker, Workers: workers}
+		phase.MaxInFlight = splitLimit(phase.MaxInFlight, worker, workers)
+		phase.QueueSize = splitLimit(phase.QueueSize, worker, workers)
+		most := max(phase.StartRPS, phase.EndRPS, phase.StartUsers, phase.EndUsers)
+		return phase, share(most, worker, workers) > 0
+	}
+	phase.StartRPS = share(phase.StartRPS, worker, workers)
+	phase.EndRPS = share(phase.EndRPS, worker, workers)
+	phase.BurstRPS = share(phase.BurstRPS, worker, workers)
+	phase.StartUsers = share(phase.StartUsers, worker, workers)
+	phase.EndUsers = share(phase.EndUsers, worker, workers)
+	phase.MaxInFlight = splitLimit(phase.MaxInFlight, worker, workers)
+	phase.QueueSize = splitLimit(phase.QueueSize, worker, workers)
+
+	if phase.Type == "trace" {
+		// every worker replays the whole trace, thinned to its share
+		scale := phase.RPSScale
+		if scale == 0 {
+			scale = 1
+		}
+		phase.RPSScale = scale / float64(workers)
+		return phase, true
+	}
+	return phase, phase.StartRPS > 0 || phase.EndRPS > 0 || phase.BurstRPS > 0 || phase.StartUsers > 0 || phase.EndUsers > 0
+}
+
+// share divides total into workers parts that differ by at most one.
+func share(total, worker, workers int) int {
+	n := total / workers
+	if worker < total%workers {
+		n++
+	}
+	return n
+}
+
+// splitLimit divides a limit where 0 means unlimited, so every worker keeps at least 1.
+func splitLimit(limit, worker, workers int) int {
+	if limit <= 0 {
+		return limit
+	}
+	return max(share(limit, worker, workers), 1)
+}
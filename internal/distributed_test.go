@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplitPhase(t *testing.T) {
+	tests := []struct {
+		name   string
+		phase  TestPhase
+		worker int
+		want   TestPhase
+		keep   bool
+	}{
+		{
+			name:   "remainder goes to the first worker",
+			phase:  TestPhase{Type: "constant", StartRPS: 10},
+			worker: 0,
+			want:   TestPhase{Type: "constant", StartRPS: 4},
+			keep:   true,
+		},
+		{
+			name:   "later worker",
+			phase:  TestPhase{Type: "poisson", StartRPS: 10},
+			worker: 2,
+			want:   TestPhase{Type: "poisson", StartRPS: 3},
+			keep:   true,
+		},
+		{
+			name:   "ramps keep their rates and step",
+			phase:  TestPhase{Type: "variable", StartRPS: 30, EndRPS: 3, Step: -1},
+			worker: 2,
+			want:   TestPhase{Type: "variable", StartRPS: 30, EndRPS: 3, Step: -1},
+			keep:   true,
+		},
+		{
+			name:   "nothing left for the worker",
+			phase:  TestPhase{Type: "constant", StartRPS: 2},
+			worker: 2,
+			want:   TestPhase{Type: "constant"},
+			keep:   false,
+		},
+		{
+			name:   "limits keep at least one slot",
+			phase:  TestPhase{Type: "closed", StartUsers: 3, MaxInFlight: 2},
+			worker: 2,
+			want:   TestPhase{Type: "closed", StartUsers: 1, MaxInFlight: 1},
+			keep:   true,
+		},
+		{
+			name:   "trace phases are thinned",
+			phase:  TestPhase{Type: "trace", RPSScale: 6},
+			worker: 1,
+			want:   TestPhase{Type: "trace", RPSScale: 2},
+			keep:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, keep := splitPhase(tt.phase, tt.worker, 3)
+			if keep != tt.keep {
+				t.Errorf("Expected keep %v, got %v", tt.keep, keep)
+			}
+			if got.StartRPS != tt.want.StartRPS || got.EndRPS != tt.want.EndRPS || got.Step != tt.want.Step ||
+				got.StartUsers != tt.want.StartUsers || got.MaxInFlight != tt.want.MaxInFlight || got.RPSScale != tt.want.RPSScale {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSplitPhase_RampSharesAddUp(t *testing.T) {
+	for _, phase := range []TestPhase{
+		{Type: "variable", StartRPS: 30, EndRPS: 3, Step: -1},
+		{Type: "variable", StartRPS: 10, EndRPS: 101, Step: 10},
+		{Type: "closed", StartUsers: 2, EndUsers: 7, Step: 1},
+	} {
+		start, end := phase.StartRPS, phase.EndRPS
+		if phase.Type == "closed" {
+			start, end = phase.StartUsers, phase.EndUsers
+		}
+		for second := range 40 {
+			sum := 0
+			for worker := range 3 {
+				if split, ok := splitPhase(phase, worker, 3); ok {
+					sum += split.ramp(start, end, second)
+				}
+			}
+			if want := rampValue(start, end, phase.Step, second); sum != want {
+				t.Errorf("%+v: expected the workers to send %d in second %d together, got %d", phase, want, second, sum)
+			}
+		}
+	}
+}
+
+func TestCoordinator_Run(t *testing.T) {
+	// sends StartRPS calls right away instead of calling a Leaf
+	RegisterExecutor("test-distributed", func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+		return &instantExecutor{collector: collector}
+	})
+	defer delete(executors, "test-distributed")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var workers []string
+	for range 2 {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lis.Close()
+		go NewWorker(logger).Serve(lis)
+		workers = append(workers, lis.Addr().String())
+	}
+
+	config := &Config{
+		LeafAddress: "127.0.0.1:1",
+		MaxDuration: 10 * time.Second,
+		Timeout:     1,
+		Workload: &Workload{Phases: []TestPhase{
+			{Name: "wide", Type: "test-distributed", StartRPS: 5, ImageTag: "echo:latest", FunctionID: "f1"},
+			{Name: "narrow", Type: "test-distributed", StartRPS: 1, ImageTag: "echo:latest", FunctionID: "f1"},
+		}},
+	}
+	summary := NewSummary()
+	collector := NewCollector(filepath.Join(t.TempDir(), "results.jsonl"), "")
+	controller := NewController(logger, WithConfig(config), WithCollector(collector), WithObserver(summary))
+
+	if err := NewCoordinator(controller, workers, 100*time.Millisecond).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if written := collector.Stats().Written; written != 6 {
+		t.Errorf("Expected 6 results from both workers, got %d", written)
+	}
+	report := summary.Report()
+	if report.Interrupted {
+		t.Errorf("Expected the run to complete")
+	}
+	requests := make(map[string]int64)
+	for _, row := range report.Phases {
+		requests[row.Name] = row.Requests
+	}
+	if requests["wide"] != 5 || requests["narrow"] != 1 {
+		t.Errorf("Expected 5 and 1 requests, got %v", requests)
+	}
+}
+
+func TestCoordinator_RunFailedWorker(t *testing.T) {
+	RegisterExecutor("test-distributed", func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+		return &instantExecutor{collector: collector}
+	})
+	defer delete(executors, "test-distributed")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go NewWorker(logger).Serve(lis)
+	// the worker rejects the second run while it waits to start the first
+	workers := []string{lis.Addr().String(), lis.Addr().String()}
+
+	config := &Config{
+		LeafAddress: "127.0.0.1:1",
+		MaxDuration: 10 * time.Second,
+		Timeout:     1,
+		Workload: &Workload{Phases: []TestPhase{
+			{Name: "wide", Type: "test-distributed", StartRPS: 4, ImageTag: "echo:latest", FunctionID: "f1"},
+		}},
+	}
+	summary := NewSummary()
+	accuracy := NewAccuracy(0, logger)
+	collector := NewCollector(filepath.Join(t.TempDir(), "results.jsonl"), "")
+	controller := NewController(logger, WithConfig(config), WithCollector(collector), WithObserver(summary), WithObserver(accuracy))
+
+	if err := NewCoordinator(controller, workers, 500*time.Millisecond).Run(context.Background()); err == nil {
+		t.Fatal("Expected an error for the failed worker")
+	}
+
+	if written := collector.Stats().Written; written != 2 {
+		t.Errorf("Expected 2 results from the other worker, got %d", written)
+	}
+	report := summary.Report()
+	if len(report.Failures) != 1 {
+		t.Errorf("Expected 1 failure in the summary, got %v", report.Failures)
+	}
+	if report.status() != "failed" {
+		t.Errorf("Expected a failed run, got %s", report.status())
+	}
+	if accuracy.Report().Valid {
+		t.Errorf("Expected the accuracy report to be invalid")
+	}
+}
+
+type instantExecutor struct {
+	collector DataCollector
+}
+
+func (e *instantExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	for range phase.StartRPS {
+		e.collector.Collect(CallResult{
+			Timestamp:  time.Now(),
+			Phase:      phase.Name,
+			FunctionID: phase.FunctionID,
+			ImageTag:   phase.ImageTag,
+			Latency:    time.Millisecond,
+			Outcome:    OutcomeSent,
+		})
+	}
+	return nil
+}
+
+func (e *instantExecutor) Stop() {}
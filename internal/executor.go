@@ -2,11 +2,20 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/3s-rg-codes/HyperFaaS/proto/common"
 	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type LoadExecutor interface {
@@ -14,135 +23,360 @@ type LoadExecutor interface {
 	Stop()
 }
 
-type client interface {
+// Client sends a call to a Leaf, or to several like the LeafBalancer.
+type Client interface {
 	ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRequest) (CallResult, error)
 }
 
-type dataCollector interface {
+// DataCollector receives the result of every call.
+type DataCollector interface {
 	Collect(result CallResult)
 }
 
+// ExecutorFactory creates the executor that runs a single phase.
+type ExecutorFactory func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor
+
+var (
+	executorsMu sync.RWMutex
+	// executors maps each phase type to the factory of its executor
+	executors = map[string]ExecutorFactory{
+		"constant": func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+			return NewConstantExecutor(client, collector, funcMgr, l, dataProvider, limiter)
+		},
+		"variable": func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+			return NewRampingExecutor(client, collector, funcMgr, l, dataProvider, limiter)
+		},
+		"poisson": func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+			return NewPoissonExecutor(client, collector, funcMgr, l, dataProvider, limiter)
+		},
+		"mmpp": func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+			return NewMMPPExecutor(client, collector, funcMgr, l, dataProvider, limiter)
+		},
+		"spike": func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+			return NewSpikeExecutor(client, collector, funcMgr, l, dataProvider, limiter)
+		},
+		"trace": func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+			return NewTraceExecutor(client, collector, funcMgr, l, dataProvider, limiter)
+		},
+		"closed": func(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) LoadExecutor {
+			return NewClosedExecutor(client, collector, funcMgr, l, dataProvider, limiter)
+		},
+	}
+)
+
+// RegisterExecutor makes a new phase type available, replacing any executor
+// registered for it before. Configs loaded before the registration do not know
+// the phase type.
+func RegisterExecutor(phaseType string, factory ExecutorFactory) {
+	executorsMu.Lock()
+	defer executorsMu.Unlock()
+	executors[phaseType] = factory
+}
+
+// executorFor returns the factory registered for a phase type.
+func executorFor(phaseType string) (ExecutorFactory, bool) {
+	executorsMu.RLock()
+	defer executorsMu.RUnlock()
+	factory, ok := executors[phaseType]
+	return factory, ok
+}
+
+// PhaseTypes returns the registered phase types in alphabetical order.
+func PhaseTypes() []string {
+	executorsMu.RLock()
+	defer executorsMu.RUnlock()
+	return slices.Sorted(maps.Keys(executors))
+}
+
+// defaultDrainTimeout bounds how long an executor waits for its in-flight calls
+// after it stopped sending new ones.
+const defaultDrainTimeout = 10 * time.Second
+
+// cancelGrace is how long the calls cancelled after the drain timeout get to be collected.
+const cancelGrace = time.Second
+
+// stopper lets Stop end a running Execute early and tracks the executor's
+// in-flight calls so they can be drained before Execute returns.
+type stopper struct {
+	once    sync.Once
+	stop    chan struct{}
+	done    chan struct{}
+	started atomic.Bool
+	calls   sync.WaitGroup
+}
+
+func newStopper() stopper {
+	return stopper{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Stop ends scheduling of new calls and waits until Execute has drained the
+// calls still in flight.
+func (s *stopper) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	if s.started.Load() {
+		<-s.done
+	}
+}
+
+// begin returns the context of the calls and a context of the scheduling that is
+// cancelled when Stop is called. finish must be called once scheduling is over;
+// it waits up to drainTimeout for in-flight calls and then cancels those still
+// running.
+func (s *stopper) begin(ctx context.Context, drainTimeout time.Duration) (callCtx, runCtx context.Context, finish func() error) {
+	s.started.Store(true)
+	callCtx, cancelCalls := context.WithCancel(ctx)
+	runCtx, cancel := context.WithCancel(callCtx)
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	return callCtx, runCtx, func() error {
+		cancel()
+		defer close(s.done)
+		defer cancelCalls()
+		return s.drain(drainTimeout, cancelCalls)
+	}
+}
+
+func (s *stopper) drain(timeout time.Duration, cancelCalls context.CancelFunc) error {
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	drained := make(chan struct{})
+	go func() {
+		s.calls.Wait()
+		close(drained)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-drained:
+		return nil
+	case <-t.C:
+	}
+	// the phase ends here, the cancelled calls are collected as they return
+	cancelCalls()
+	select {
+	case <-drained:
+	case <-time.After(cancelGrace):
+	}
+	return fmt.Errorf("calls still in flight after drain timeout of %v, cancelled them", timeout)
+}
+
 type ConstantExecutor struct {
+	stopper
 	rps          int
-	client       client
-	collector    dataCollector
+	client       Client
+	collector    DataCollector
 	funcMgr      *FunctionManager
 	l            *slog.Logger
 	dataProvider DataProvider
+	limiter      *InFlightLimiter
 }
 
-func NewConstantExecutor(client client, collector dataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider) *ConstantExecutor {
+func NewConstantExecutor(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) *ConstantExecutor {
 	return &ConstantExecutor{
+		stopper:      newStopper(),
 		client:       client,
 		collector:    collector,
 		funcMgr:      funcMgr,
 		l:            l,
 		dataProvider: dataProvider,
+		limiter:      limiter,
 	}
 }
 
 type RampingExecutor struct {
+	stopper
 	startRPS     int
 	endRPS       int
 	step         int
 	duration     time.Duration
-	client       client
-	collector    dataCollector
+	client       Client
+	collector    DataCollector
 	funcMgr      *FunctionManager
 	l            *slog.Logger
 	dataProvider DataProvider
+	limiter      *InFlightLimiter
 }
 
-func NewRampingExecutor(client client, collector dataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider) *RampingExecutor {
+func NewRampingExecutor(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) *RampingExecutor {
 	return &RampingExecutor{
+		stopper:      newStopper(),
 		client:       client,
 		collector:    collector,
 		funcMgr:      funcMgr,
 		l:            l,
 		dataProvider: dataProvider,
+		limiter:      limiter,
 	}
 }
 
-func (e *ConstantExecutor) Execute(ctx context.Context, phase TestPhase) {
+func (e *ConstantExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	if phase.StartRPS <= 0 {
+		return fmt.Errorf("start RPS must be positive, got %d", phase.StartRPS)
+	}
 	e.rps = phase.StartRPS
 
-	subCtx, cancel := context.WithTimeout(ctx, phase.Duration)
+	ctx, runCtx, finish := e.begin(ctx, phase.DrainTimeout)
+	subCtx, cancel := context.WithTimeout(runCtx, phase.Duration)
 	defer cancel()
 
-	t := time.NewTicker(time.Second)
-
-	for {
-		select {
-		case <-subCtx.Done():
-			return
-		case <-t.C:
-			e.l.Debug("Constant executor", "Current RPS", e.rps)
-			for i := 0; i < e.rps; i++ {
-
-				go func() {
-					data := e.dataProvider.GetData()
-					result, _ := e.client.ScheduleCall(ctx, &leaf.ScheduleCallRequest{
-						FunctionID: &common.FunctionID{
-							Id: phase.FunctionID,
-						},
-						Data: data,
-					})
-					result.ImageTag = phase.ImageTag
-					result.RequestSize = int64(len(data))
-					e.collector.Collect(result)
-				}()
-
-			}
-		}
-	}
+	e.l.Debug("Constant executor", "Current RPS", e.rps, "Arrival", phase.Arrival)
+	scheduler := NewArrivalScheduler(time.Now(), phase.Arrival, uint64(time.Now().UnixNano()))
+	scheduler.Run(subCtx, func(int) int {
+		return e.rps
+	}, func(intended time.Time) {
+		dispatchCall(ctx, subCtx, &e.calls, e.limiter, e.client, e.collector, e.dataProvider, phase, intended)
+	})
+	return finish()
 }
 
-func (e *RampingExecutor) Execute(ctx context.Context, phase TestPhase) {
+func (e *RampingExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	if phase.Step == 0 {
+		return errors.New("step must not be 0 for a ramping phase")
+	}
 	e.startRPS = phase.StartRPS
 	e.endRPS = phase.EndRPS
 	e.step = phase.Step
-	incrementing := e.step > 0
 
-	subCtx, cancel := context.WithTimeout(ctx, phase.Duration)
+	if e.startRPS == 0 {
+		e.l.Warn("Start RPS is 0, setting to 1")
+		e.startRPS = 1
+	}
+
+	ctx, runCtx, finish := e.begin(ctx, phase.DrainTimeout)
+	subCtx, cancel := context.WithTimeout(runCtx, phase.Duration)
 	defer cancel()
 
-	currentRPS := e.startRPS
-	if currentRPS == 0 {
-		e.l.Warn("Start RPS is 0, setting to 1")
-		currentRPS = 1
+	scheduler := NewArrivalScheduler(time.Now(), phase.Arrival, uint64(time.Now().UnixNano()))
+	current := -1
+	scheduler.Run(subCtx, func(second int) int {
+		rps := phase.ramp(e.startRPS, e.endRPS, second)
+		if second != current {
+			current = second
+			e.l.Debug("Ramping executor", "Current RPS", rps)
+		}
+		return rps
+	}, func(intended time.Time) {
+		dispatchCall(ctx, subCtx, &e.calls, e.limiter, e.client, e.collector, e.dataProvider, phase, intended)
+	})
+	return finish()
+}
+
+// ramp returns the target (RPS or users) of the phase for the given second of
+// its ramp from start to end, or the worker's share of it.
+func (p TestPhase) ramp(start, end, second int) int {
+	v := rampValue(start, end, p.Step, second)
+	if p.Share != nil {
+		v = share(v, p.Share.Worker, p.Share.Workers)
 	}
+	return v
+}
 
-	t := time.NewTicker(time.Second)
-	first := true
-	for {
-		select {
-		case <-subCtx.Done():
-			return
-		case <-t.C:
-			e.l.Debug("Ramping executor", "Current RPS", currentRPS)
-			if !first && (incrementing && currentRPS < e.endRPS || !incrementing && currentRPS > e.endRPS) {
-				currentRPS += e.step
-			}
-
-			if currentRPS <= 0 {
-				break
-			}
-			first = false
-
-			for i := 0; i < currentRPS; i++ {
-				go func() {
-					data := e.dataProvider.GetData()
-					result, _ := e.client.ScheduleCall(ctx, &leaf.ScheduleCallRequest{
-						FunctionID: &common.FunctionID{
-							Id: phase.FunctionID,
-						},
-						Data: data,
-					})
-					result.ImageTag = phase.ImageTag
-					result.RequestSize = int64(len(data))
-					e.collector.Collect(result)
-				}()
-			}
+// rampValue returns the target (RPS or users) for the given second of a ramp,
+// moving by step every second and holding at end once it is reached.
+func rampValue(start, end, step, second int) int {
+	v := start + step*second
+	if step > 0 && v > end || step < 0 && v < end {
+		v = end
+	}
+	return v
+}
+
+// dispatchCall sends a call in the background once the limiter allows it and
+// records a dropped row if it is never sent. waitCtx bounds how long the call may
+// wait for an in-flight slot, ctx is used for the call itself. calls tracks the
+// call until it is collected.
+func dispatchCall(ctx, waitCtx context.Context, calls *sync.WaitGroup, limiter *InFlightLimiter, c Client, collector DataCollector, dataProvider DataProvider, phase TestPhase, intended time.Time) {
+	calls.Add(1)
+	limiter.Go(waitCtx, func(waited bool) {
+		defer calls.Done()
+		sendCall(ctx, c, collector, dataProvider, phase, intended, waited)
+	}, func(err error) {
+		defer calls.Done()
+		code := codes.ResourceExhausted
+		if !errors.Is(err, ErrDropped) {
+			code = status.FromContextError(err).Code()
+		}
+		collector.Collect(CallResult{
+			Timestamp:         time.Now(),
+			IntendedTimestamp: intended,
+			FunctionID:        phase.FunctionID,
+			ImageTag:          phase.ImageTag,
+			Phase:             phase.Name,
+			Status:            code,
+			Error:             err.Error(),
+			Connection:        noConnection,
+			Outcome:           OutcomeDropped,
+		})
+	})
+}
+
+// sendCall sends a single call and collects its result. late marks calls that
+// had to wait for an in-flight slot. Failed calls are resent as the phase's
+// retry policy allows; the result is that of the last attempt.
+func sendCall(ctx context.Context, c Client, collector DataCollector, dataProvider DataProvider, phase TestPhase, intended time.Time, late bool) {
+	data := dataProvider.GetData()
+	if o, ok := collector.(callObserver); ok {
+		o.CallStarted(phase)
+	}
+	req := &leaf.ScheduleCallRequest{
+		FunctionID: &common.FunctionID{
+			Id: phase.callFunctionID(),
+		},
+		Data: data,
+	}
+	var result CallResult
+	var firstSent time.Time
+	for attempt := 1; ; attempt++ {
+		result = scheduleCall(ctx, c, req, phase.CallTimeout)
+		if attempt == 1 {
+			firstSent = result.Timestamp
+		}
+		result.Attempts = attempt
+		result.FirstSentTimestamp = firstSent
+		if !phase.Retry.shouldRetry(result.Status, attempt) || !sleepCtx(ctx, phase.Retry.backoff(attempt)) {
+			break
 		}
 	}
+	result.ImageTag = phase.ImageTag
+	result.Phase = phase.Name
+	result.RequestSize = int64(len(data))
+	result.IntendedTimestamp = intended
+	result.Outcome = OutcomeSent
+	if late {
+		result.Outcome = OutcomeLate
+	}
+	collector.Collect(result)
+}
+
+// callFunctionID picks the function of a call, at random among the phase's
+// functions so that the load is shared evenly.
+func (p TestPhase) callFunctionID() string {
+	if len(p.FunctionIDs) > 1 {
+		return p.FunctionIDs[rand.IntN(len(p.FunctionIDs))]
+	}
+	return p.FunctionID
+}
+
+// scheduleCall sends one attempt, bounded by timeout if set.
+func scheduleCall(ctx context.Context, c Client, req *leaf.ScheduleCallRequest, timeout time.Duration) CallResult {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	result, _ := c.ScheduleCall(ctx, req)
+	return result
 }
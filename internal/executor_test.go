@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExecutors_ImplementLoadExecutor(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, phaseType := range PhaseTypes() {
+		if executors[phaseType](&slowClient{}, &memoryCollector{}, nil, l, NewEchoDataProvider(8, 16), nil) == nil {
+			t.Errorf("Factory for phase type %s returned nil", phaseType)
+		}
+	}
+}
+
+func TestConstantExecutor_StopDrainsInFlightCalls(t *testing.T) {
+	client := &slowClient{latency: 50 * time.Millisecond}
+	collector := &memoryCollector{}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor := NewConstantExecutor(client, collector, nil, l, NewEchoDataProvider(8, 16), nil)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- executor.Execute(context.Background(), TestPhase{
+			Name:     "constant",
+			Type:     "constant",
+			Duration: time.Minute,
+			StartRPS: 100,
+			ImageTag: "test:latest",
+		})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	executor.Stop()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("Execute() returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Execute to return after Stop")
+	}
+
+	if n := client.inFlight.Load(); n != 0 {
+		t.Errorf("Expected no calls in flight after Stop, got %d", n)
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.results) == 0 {
+		t.Error("Expected the calls sent before Stop to be collected")
+	}
+}
+
+func TestStopper_DrainTimeout(t *testing.T) {
+	s := newStopper()
+	callCtx, _, finish := s.begin(context.Background(), 20*time.Millisecond)
+
+	s.calls.Add(1)
+	go func() {
+		defer s.calls.Done()
+		<-callCtx.Done()
+	}()
+	if err := finish(); err == nil {
+		t.Error("Expected an error when calls do not drain in time")
+	}
+	if callCtx.Err() == nil {
+		t.Error("Expected the calls still in flight to be cancelled")
+	}
+}
+
+func TestExecute_RejectsInvalidPhase(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor := NewRampingExecutor(&slowClient{}, &memoryCollector{}, nil, l, NewEchoDataProvider(8, 16), nil)
+	if err := executor.Execute(context.Background(), TestPhase{Type: "variable", Duration: time.Second, StartRPS: 1, EndRPS: 10}); err == nil {
+		t.Error("Expected an error for a ramping phase without step")
+	}
+	// Stop must not block on an executor that never started
+	executor.Stop()
+}
+
+func TestController_SkipsUnknownPhaseType(t *testing.T) {
+	config := &Config{
+		MaxDuration: 10 * time.Second,
+		Timeout:     10,
+		Workload: &Workload{Phases: []TestPhase{
+			{Name: "steady", Type: "constant", StartRPS: 10, Duration: 300 * time.Millisecond, ImageTag: "hyperfaas-echo:latest"},
+			{Name: "typo", Type: "constnat", StartRPS: 10, Duration: 300 * time.Millisecond, ImageTag: "hyperfaas-echo:latest"},
+		}},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	summary := NewSummary()
+	collector := NewCollector(filepath.Join(t.TempDir(), "results.csv"), "")
+	NewController(logger, WithConfig(config), WithFakeLeaf(), WithCollector(collector), WithObserver(summary)).Run(t.Context())
+
+	requests := make(map[string]int64)
+	for _, row := range summary.Report().Phases {
+		requests[row.Name] = row.Requests
+	}
+	if requests["steady"] == 0 || requests["typo"] != 0 {
+		t.Errorf("Expected only the known phase to send calls, got %v", requests)
+	}
+}
+
+func TestTargetRPS(t *testing.T) {
+	tests := []struct {
+		name     string
+		phase    TestPhase
+		elapsed  time.Duration
+		expected float64
+		ok       bool
+	}{
+		{"constant", TestPhase{Type: "constant", StartRPS: 10, Duration: time.Minute}, 5 * time.Second, 10, true},
+		{"variable", TestPhase{Type: "variable", StartRPS: 10, EndRPS: 50, Step: 5, Duration: time.Minute}, 3 * time.Second, 25, true},
+		{"spike", TestPhase{Type: "spike", StartRPS: 10, BurstRPS: 100, BurstDuration: time.Second, BurstInterval: 10 * time.Second, Duration: time.Minute}, 10 * time.Second, 100, true},
+		{"mmpp", TestPhase{Type: "mmpp", StartRPS: 0, BurstRPS: 100, BurstDuration: time.Second, IdleDuration: 3 * time.Second, Duration: time.Minute}, time.Second, 25, true},
+		{"after_phase", TestPhase{Type: "constant", StartRPS: 10, Duration: time.Minute}, time.Minute, 0, true},
+		{"closed", TestPhase{Type: "closed", StartUsers: 10, Duration: time.Minute}, time.Second, 0, false},
+		{"trace_after_phase", TestPhase{Type: "trace", Duration: time.Minute}, time.Minute, 0, false},
+		{"no_duration", TestPhase{Type: "closed", StartUsers: 10}, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rps, ok := TargetRPS(tt.phase, tt.elapsed)
+			if rps != tt.expected || ok != tt.ok {
+				t.Errorf("TargetRPS() = (%v, %v), expected (%v, %v)", rps, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/3s-rg-codes/HyperFaaS/proto/common"
+	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultFakeLatency      = 5 * time.Millisecond
+	defaultFakeScheduling   = 50 * time.Microsecond
+	defaultFakeInstanceIdle = 30 * time.Second
+	defaultFakeSigma        = 0.5
+)
+
+// FakeLeafConfig describes how the fake Leaf answers. The zero value answers
+// every call after 5ms.
+type FakeLeafConfig struct {
+	Latency FakeLatency `yaml:"latency"`
+	// Scheduling is the time between receiving a call and handing it to an instance, 50µs if unset
+	Scheduling time.Duration `yaml:"scheduling,omitempty"`
+	// ColdStart is added to the scheduling time of calls that start a new instance
+	ColdStart time.Duration `yaml:"cold_start,omitempty"`
+	// InstanceIdle is how long an instance lives without calls, 30s if unset
+	InstanceIdle time.Duration `yaml:"instance_idle,omitempty"`
+	// Errors fails calls with the given probability per gRPC status name, e.g. Unavailable: 0.01
+	Errors map[string]float64 `yaml:"errors,omitempty"`
+}
+
+// FakeLatency is the distribution of the function processing time.
+type FakeLatency struct {
+	Distribution string        `yaml:"distribution,omitempty"` // "constant" (default) | "uniform" | "exponential" | "lognormal"
+	Mean         time.Duration `yaml:"mean,omitempty"`         // 5ms if unset
+	Min          time.Duration `yaml:"min,omitempty"`          // lower bound, and the start of the uniform range
+	Max          time.Duration `yaml:"max,omitempty"`          // upper bound, and the end of the uniform range
+	Sigma        float64       `yaml:"sigma,omitempty"`        // shape of the lognormal distribution, 0.5 if unset
+}
+
+// LoadFakeLeafConfig reads and validates a fake Leaf config file.
+func LoadFakeLeafConfig(path string) (*FakeLeafConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &FakeLeafConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, config.validate()
+}
+
+func (c *FakeLeafConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Latency.Distribution {
+	case "", "constant", "exponential", "lognormal":
+	case "uniform":
+		if c.Latency.Max <= c.Latency.Min {
+			return fmt.Errorf("fake leaf: uniform latency needs a max above min")
+		}
+	default:
+		return fmt.Errorf("fake leaf: latency distribution must be constant, uniform, exponential or lognormal, got %q", c.Latency.Distribution)
+	}
+	total := 0.0
+	for name, p := range c.Errors {
+		if _, ok := parseCode(name); !ok {
+			return fmt.Errorf("fake leaf: unknown gRPC status %q", name)
+		}
+		if p < 0 {
+			return fmt.Errorf("fake leaf: error probability of %s must not be negative", name)
+		}
+		total += p
+	}
+	if total > 1 {
+		return fmt.Errorf("fake leaf: error probabilities add up to more than 1")
+	}
+	return nil
+}
+
+// sample draws a processing time.
+func (l FakeLatency) sample(r *rand.Rand) time.Duration {
+	mean := float64(l.Mean)
+	if mean == 0 {
+		mean = float64(defaultFakeLatency)
+	}
+	var d float64
+	switch l.Distribution {
+	case "uniform":
+		d = float64(l.Min) + r.Float64()*float64(l.Max-l.Min)
+	case "exponential":
+		d = r.ExpFloat64() * mean
+	case "lognormal":
+		sigma := l.Sigma
+		if sigma == 0 {
+			sigma = defaultFakeSigma
+		}
+		// keeps the mean at Mean
+		d = mean * math.Exp(sigma*r.NormFloat64()-sigma*sigma/2)
+	default:
+		d = mean
+	}
+	d = max(d, float64(l.Min))
+	if l.Max > 0 {
+		d = min(d, float64(l.Max))
+	}
+	return time.Duration(d)
+}
+
+// FakeLeaf implements the Leaf gRPC service without running functions. Each
+// function gets instances that serve one call at a time; a call that finds no
+// idle instance starts a new one and pays the cold start. Responses echo the
+// request data and carry the trailers of a real Leaf.
+type FakeLeaf struct {
+	leaf.UnimplementedLeafServer
+
+	config FakeLeafConfig
+	l      *slog.Logger
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	functions map[string]*fakeFunction
+	created   int
+}
+
+type fakeFunction struct {
+	imageTag  string
+	instances []*fakeInstance
+	spawned   int
+}
+
+type fakeInstance struct {
+	id       string
+	busy     bool
+	lastUsed time.Time
+}
+
+func NewFakeLeaf(config *FakeLeafConfig, l *slog.Logger) *FakeLeaf {
+	f := &FakeLeaf{
+		l:         l,
+		rand:      rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		functions: make(map[string]*fakeFunction),
+	}
+	if config != nil {
+		f.config = *config
+	}
+	return f
+}
+
+// Serve answers calls on lis until it is closed.
+func (f *FakeLeaf) Serve(lis net.Listener) error {
+	server := grpc.NewServer()
+	leaf.RegisterLeafServer(server, f)
+	f.l.Info("Fake Leaf listening", "Address", lis.Addr())
+	return server.Serve(lis)
+}
+
+// Start serves on a free localhost port and returns its address.
+func (f *FakeLeaf) Start() (string, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := f.Serve(lis); err != nil {
+			f.l.Error("Fake Leaf stopped", "Error", err)
+		}
+	}()
+	return lis.Addr().String(), nil
+}
+
+func (f *FakeLeaf) CreateFunction(ctx context.Context, req *leaf.CreateFunctionRequest) (*leaf.CreateFunctionResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created++
+	id := fmt.Sprintf("fake-%d", f.created)
+	f.functions[id] = &fakeFunction{imageTag: req.GetImageTag().GetTag()}
+	f.l.Debug("Fake Leaf created function", "FunctionID", id, "ImageTag", req.GetImageTag().GetTag())
+	return &leaf.CreateFunctionResponse{FunctionID: &common.FunctionID{Id: id}}, nil
+}
+
+// RemoveFunction deletes a function, later calls to it fail with NotFound.
+func (f *FakeLeaf) RemoveFunction(ctx context.Context, functionID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.functions[functionID]; !ok {
+		return status.Errorf(codes.NotFound, "function %s not found", functionID)
+	}
+	delete(f.functions, functionID)
+	f.l.Debug("Fake Leaf removed function", "FunctionID", functionID)
+	return nil
+}
+
+func (f *FakeLeaf) ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRequest) (*leaf.ScheduleCallResponse, error) {
+	gotRequest := time.Now()
+	instance, cold, processing, failure, err := f.schedule(req.GetFunctionID().GetId(), gotRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduling := f.config.Scheduling
+	if scheduling == 0 {
+		scheduling = defaultFakeScheduling
+	}
+	if cold {
+		scheduling += f.config.ColdStart
+	}
+	if !sleepCtx(ctx, scheduling) {
+		f.release(instance)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	scheduled := time.Now()
+	if failure != codes.OK {
+		f.release(instance)
+		return nil, status.Errorf(failure, "injected by the fake Leaf")
+	}
+
+	ok := sleepCtx(ctx, processing)
+	f.release(instance)
+	if !ok {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+
+	grpc.SetTrailer(ctx, metadata.Pairs(
+		"leafGotRequestTimestamp", strconv.FormatInt(gotRequest.UnixNano(), 10),
+		"leafScheduledCallTimestamp", strconv.FormatInt(scheduled.UnixNano(), 10),
+		"callQueuedTimestamp", strconv.FormatInt(scheduled.UnixNano(), 10),
+		"gotResponseTimestamp", strconv.FormatInt(time.Now().UnixNano(), 10),
+		"functionProcessingTime", strconv.FormatInt(processing.Nanoseconds(), 10),
+		"instanceId", instance.id,
+	))
+	return &leaf.ScheduleCallResponse{Data: req.Data}, nil
+}
+
+// schedule takes an idle instance of the function or starts a new one, and
+// draws the processing time and the injected failure of the call.
+func (f *FakeLeaf) schedule(functionID string, now time.Time) (instance *fakeInstance, cold bool, processing time.Duration, failure codes.Code, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn, ok := f.functions[functionID]
+	if !ok {
+		return nil, false, 0, codes.OK, status.Errorf(codes.NotFound, "function %s not found", functionID)
+	}
+
+	idle := f.config.InstanceIdle
+	if idle == 0 {
+		idle = defaultFakeInstanceIdle
+	}
+	fn.instances = slices.DeleteFunc(fn.instances, func(i *fakeInstance) bool {
+		return !i.busy && now.Sub(i.lastUsed) > idle
+	})
+	for _, i := range fn.instances {
+		if !i.busy {
+			instance = i
+			break
+		}
+	}
+	if instance == nil {
+		fn.spawned++
+		instance = &fakeInstance{id: fmt.Sprintf("%s-instance-%d", functionID, fn.spawned)}
+		fn.instances = append(fn.instances, instance)
+		cold = true
+	}
+	instance.busy = true
+
+	processing = f.config.Latency.sample(f.rand)
+	failure = codes.OK
+	roll := f.rand.Float64()
+	for _, name := range slices.Sorted(maps.Keys(f.config.Errors)) {
+		roll -= f.config.Errors[name]
+		if roll < 0 {
+			failure, _ = parseCode(name)
+			break
+		}
+	}
+	return instance, cold, processing, failure, nil
+}
+
+func (f *FakeLeaf) release(instance *fakeInstance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	instance.busy = false
+	instance.lastUsed = time.Now()
+}
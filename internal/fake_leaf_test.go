@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestFakeLatency_Sample(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	tests := []struct {
+		name     string
+		latency  FakeLatency
+		min, max time.Duration
+		mean     time.Duration
+	}{
+		{"default", FakeLatency{}, 5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond},
+		{"uniform", FakeLatency{Distribution: "uniform", Min: time.Millisecond, Max: 3 * time.Millisecond}, time.Millisecond, 3 * time.Millisecond, 2 * time.Millisecond},
+		{"exponential", FakeLatency{Distribution: "exponential", Mean: 10 * time.Millisecond}, 0, time.Hour, 10 * time.Millisecond},
+		{"lognormal", FakeLatency{Distribution: "lognormal", Mean: 10 * time.Millisecond}, 0, time.Hour, 10 * time.Millisecond},
+		{"clamped", FakeLatency{Distribution: "exponential", Mean: 10 * time.Millisecond, Min: 5 * time.Millisecond, Max: 20 * time.Millisecond}, 5 * time.Millisecond, 20 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sum time.Duration
+			const n = 20000
+			for range n {
+				d := tt.latency.sample(r)
+				if d < tt.min || d > tt.max {
+					t.Fatalf("Expected samples between %v and %v, got %v", tt.min, tt.max, d)
+				}
+				sum += d
+			}
+			if mean := sum / n; tt.mean > 0 && (mean < tt.mean*95/100 || mean > tt.mean*105/100) {
+				t.Errorf("Expected a mean of about %v, got %v", tt.mean, mean)
+			}
+		})
+	}
+}
+
+func startFakeLeaf(t *testing.T, config *FakeLeafConfig) (*LeafClient, string) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	address, err := NewFakeLeaf(config, logger).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewLeafClient(address, nil, insecure.NewCredentials())
+	t.Cleanup(func() { client.Close() })
+	f := NewFunctionManager(address, nil, insecure.NewCredentials()).CreateFunction("hyperfaas-echo:latest", 10, &FunctionConfig{Memory: "64MB"})
+	return client, f.ID
+}
+
+func TestFakeLeaf_ColdStarts(t *testing.T) {
+	client, functionID := startFakeLeaf(t, &FakeLeafConfig{
+		Latency:   FakeLatency{Mean: time.Millisecond},
+		ColdStart: 30 * time.Millisecond,
+	})
+	phase := TestPhase{Name: "warm", FunctionID: functionID, ImageTag: "hyperfaas-echo:latest"}
+	collector := &memoryCollector{}
+	for range 2 {
+		sendCall(context.Background(), client, collector, NewEchoDataProvider(8, 16), phase, time.Now(), false)
+	}
+
+	cold, warm := collector.results[0], collector.results[1]
+	if cold.Status != codes.OK || warm.Status != codes.OK {
+		t.Fatalf("Expected both calls to succeed, got %v and %v", cold.Error, warm.Error)
+	}
+	if cold.InstanceID == "" || cold.InstanceID != warm.InstanceID {
+		t.Errorf("Expected the second call on the first call's instance, got %q and %q", cold.InstanceID, warm.InstanceID)
+	}
+	if cold.Latency < 30*time.Millisecond || warm.Latency >= 30*time.Millisecond {
+		t.Errorf("Expected only the first call to pay the cold start, got %v and %v", cold.Latency, warm.Latency)
+	}
+	if b, ok := warm.Breakdown(); !ok || b.FunctionExecution != time.Millisecond {
+		t.Errorf("Expected a latency breakdown with 1ms of function execution, got %+v", b)
+	}
+	if warm.ResponseSize != warm.RequestSize {
+		t.Errorf("Expected the request data to be echoed, got %d of %d bytes", warm.ResponseSize, warm.RequestSize)
+	}
+}
+
+func TestFakeLeaf_Errors(t *testing.T) {
+	client, functionID := startFakeLeaf(t, &FakeLeafConfig{Errors: map[string]float64{"RESOURCE_EXHAUSTED": 1}})
+	collector := &memoryCollector{}
+	phase := TestPhase{Name: "failing", FunctionID: functionID}
+	sendCall(context.Background(), client, collector, NewEchoDataProvider(8, 16), phase, time.Now(), false)
+	phase.FunctionID = "missing"
+	sendCall(context.Background(), client, collector, NewEchoDataProvider(8, 16), phase, time.Now(), false)
+
+	if got := collector.results[0].Status; got != codes.ResourceExhausted {
+		t.Errorf("Expected an injected ResourceExhausted, got %v", got)
+	}
+	if got := collector.results[1].Status; got != codes.NotFound {
+		t.Errorf("Expected NotFound for an unknown function, got %v", got)
+	}
+}
+
+func TestController_RunAgainstFakeLeaf(t *testing.T) {
+	config := &Config{
+		MaxDuration: 10 * time.Second,
+		Timeout:     10,
+		FunctionConfig: map[string]*FunctionConfig{
+			"hyperfaas-echo:latest": {Memory: "64MB"},
+		},
+		FakeLeaf: &FakeLeafConfig{Latency: FakeLatency{Mean: 2 * time.Millisecond}},
+		Workload: &Workload{Phases: []TestPhase{
+			{Name: "steady", Type: "constant", StartRPS: 20, Duration: time.Second, ImageTag: "hyperfaas-echo:latest"},
+		}},
+	}
+	summary := NewSummary()
+	collector := NewCollector(filepath.Join(t.TempDir(), "results.csv"), "")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	NewController(logger, WithConfig(config), WithFakeLeaf(), WithCollector(collector), WithObserver(summary)).Run(context.Background())
+
+	report := summary.Report()
+	if len(report.Phases) != 1 {
+		t.Fatalf("Expected 1 phase, got %+v", report.Phases)
+	}
+	steady := report.Phases[0]
+	if steady.Sent < 18 || steady.Succeeded != steady.Sent {
+		t.Errorf("Expected about 20 successful calls, got %+v", steady)
+	}
+	if steady.Latency.P50 < 2*time.Millisecond {
+		t.Errorf("Expected latencies of at least the fake's 2ms, got %v", steady.Latency.P50)
+	}
+}
@@ -1,38 +1,150 @@
 package internal
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/3s-rg-codes/HyperFaaS/proto/common"
 	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
 )
 
+// FunctionManager creates the functions of a run and keeps track of them,
+// including the existing functions the run reuses.
 type FunctionManager struct {
-	conn      *grpc.ClientConn
-	client    leaf.LeafClient
+	conn    *grpc.ClientConn
+	client  leaf.LeafClient
+	remover functionRemover // nil if the Leafs cannot remove functions
+
+	mu        sync.Mutex
 	functions map[string]*Function
 }
 
+// functionRemover removes functions from the Leafs. The Leaf API has no call for
+// it yet, so only the in-process fake Leaf can remove functions.
+type functionRemover interface {
+	RemoveFunction(ctx context.Context, functionID string) error
+}
+
 type Function struct {
 	ID          string
 	ImageTag    string `yaml:"image_tag"`
 	Timeout     int32  `yaml:"timeout"`
 	ProtoConfig *common.Config
+	Reused      bool // taken from the config instead of created by the run
+	Removed     bool
 }
 
 type FunctionConfig struct {
-	Memory string            `yaml:"memory"`
-	Cpu    *common.CPUConfig `yaml:"cpu"`
+	Memory string `yaml:"memory"` // bytes or a quantity like 512Mi, 1.5Gi or 256MB
+	Cpu    *CPU   `yaml:"cpu"`    // cores like 0.5 or 500m, or period and quota
+	// CallTimeout overrides the global call timeout for the calls to this image tag
+	CallTimeout time.Duration `yaml:"call_timeout,omitempty"`
+	// Replicas is the number of distinct functions created for the image tag, 1 if unset
+	Replicas int `yaml:"replicas,omitempty"`
+	// FunctionIDs are existing functions of the image tag that are used instead of creating new ones
+	FunctionIDs []string `yaml:"function_ids,omitempty"`
+}
+
+const (
+	// CPU period and quota are in microseconds, within the bounds of the container runtime
+	minCPUPeriod = 1000
+	maxCPUPeriod = 1000000
+	minCPUQuota  = 1000
+)
+
+// defaultFunctionConfigs are the resources of the known image tags, used for
+// everything neither function_config nor defaults set.
+var defaultFunctionConfigs = map[string]FunctionConfig{
+	"hyperfaas-echo:latest":             {Memory: "256MB", Cpu: &CPU{Period: 100000, Quota: 50000}},
+	"hyperfaas-bfs-json:latest":         {Memory: "256MB", Cpu: &CPU{Period: 100000, Quota: 50000}},
+	"hyperfaas-thumbnailer-json:latest": {Memory: "1024MB", Cpu: &CPU{Period: 100000, Quota: 100000}},
+}
+
+// fallbackFunctionConfig is the default of all other image tags.
+var fallbackFunctionConfig = FunctionConfig{Memory: "256MB", Cpu: &CPU{Period: 100000, Quota: 50000}}
+
+// resolveFunctionConfig layers the function config of an image tag over the
+// defaults section and the built-in defaults of the tag. Fields that are not
+// set, including the CPU period and quota each, come from the layer below.
+func resolveFunctionConfig(imageTag string, defaults, config *FunctionConfig) *FunctionConfig {
+	base, ok := defaultFunctionConfigs[imageTag]
+	if !ok {
+		base = fallbackFunctionConfig
+	}
+	resolved := &FunctionConfig{
+		Memory: base.Memory,
+		Cpu:    &CPU{Period: base.Cpu.Period, Quota: base.Cpu.Quota},
+	}
+	for _, layer := range []*FunctionConfig{defaults, config} {
+		if layer == nil {
+			continue
+		}
+		resolved.Memory = cmp.Or(layer.Memory, resolved.Memory)
+		if layer.Cpu != nil {
+			resolved.Cpu.Period = cmp.Or(layer.Cpu.Period, resolved.Cpu.Period)
+			resolved.Cpu.Quota = cmp.Or(layer.Cpu.Quota, resolved.Cpu.Quota)
+		}
+		resolved.CallTimeout = cmp.Or(layer.CallTimeout, resolved.CallTimeout)
+		resolved.Replicas = cmp.Or(layer.Replicas, resolved.Replicas)
+		if len(layer.FunctionIDs) > 0 {
+			resolved.FunctionIDs = layer.FunctionIDs
+		}
+	}
+	return resolved
+}
+
+// validate checks the fields that are set, so that a function config may leave
+// the rest to the defaults.
+func (fc *FunctionConfig) validate() error {
+	if fc == nil {
+		return nil
+	}
+	if fc.Memory != "" {
+		if _, err := convertMemory(fc.Memory); err != nil {
+			return err
+		}
+	}
+	if fc.Cpu != nil {
+		if p := fc.Cpu.Period; p != 0 && (p < minCPUPeriod || p > maxCPUPeriod) {
+			return fmt.Errorf("cpu period must be between %d and %d microseconds, got %d", minCPUPeriod, maxCPUPeriod, p)
+		}
+		if q := fc.Cpu.Quota; q != 0 && q < minCPUQuota {
+			return fmt.Errorf("cpu quota must be at least %d microseconds, got %d", minCPUQuota, q)
+		}
+	}
+	if fc.CallTimeout < 0 {
+		return errors.New("call timeout must not be negative")
+	}
+	if fc.Replicas < 0 {
+		return errors.New("replicas must not be negative")
+	}
+	if fc.Replicas > 0 && len(fc.FunctionIDs) > 0 {
+		return errors.New("set either replicas or function_ids, not both")
+	}
+	return nil
+}
+
+// replicas is the number of functions to create for the image tag.
+func (fc *FunctionConfig) replicas() int {
+	if fc == nil {
+		return 1
+	}
+	return cmp.Or(fc.Replicas, 1)
 }
 
-func NewFunctionManager(leafAddress string) *FunctionManager {
-	conn, err := grpc.NewClient(leafAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewFunctionManager(leafAddress string, connection *ConnectionConfig, creds credentials.TransportCredentials) *FunctionManager {
+	conn, err := grpc.NewClient(leafAddress, connection.dialOptions(creds)...)
 	if err != nil {
 		log.Fatalf("Failed to connect to Leaf: %v", err)
 	}
@@ -45,16 +157,21 @@ func NewFunctionManager(leafAddress string) *FunctionManager {
 }
 
 func (f *FunctionManager) CreateFunction(imageTag string, timeout int32, functionConfig *FunctionConfig) *Function {
+	if functionConfig == nil {
+		functionConfig = resolveFunctionConfig(imageTag, nil, nil)
+	}
 
 	// convert string memory to int64
 	memory, err := convertMemory(functionConfig.Memory)
 	if err != nil {
-		log.Fatalf("failed to convert memory: %v", err)
+		log.Fatalf("Function config %s: %v", imageTag, err)
 	}
 
 	protoConfig := &common.Config{
 		Memory: memory,
-		Cpu:    functionConfig.Cpu,
+	}
+	if cpu := functionConfig.Cpu; cpu != nil {
+		protoConfig.Cpu = &common.CPUConfig{Period: cpu.Period, Quota: cpu.Quota}
 	}
 
 	r, err := f.client.CreateFunction(context.Background(), &leaf.CreateFunctionRequest{
@@ -67,36 +184,134 @@ func (f *FunctionManager) CreateFunction(imageTag string, timeout int32, functio
 		log.Fatalf("Failed to create function: %v", err)
 	}
 
-	return &Function{
+	function := &Function{
 		ID:          r.FunctionID.Id,
 		ImageTag:    imageTag,
 		Timeout:     timeout,
 		ProtoConfig: protoConfig,
 	}
+	f.mu.Lock()
+	f.functions[function.ID] = function
+	f.mu.Unlock()
+	return function
 }
 
-func convertMemory(memory string) (int64, error) {
-	memory = strings.ToUpper(strings.TrimSpace(memory))
+// Reuse records existing functions of the image tag that the run calls. They
+// are never removed.
+func (f *FunctionManager) Reuse(imageTag string, ids ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := f.functions[id]; !ok {
+			f.functions[id] = &Function{ID: id, ImageTag: imageTag, Reused: true}
+		}
+	}
+}
+
+// RemoveFunctions removes the functions the run created. Functions that fail to
+// be removed are kept and reported in the error.
+func (f *FunctionManager) RemoveFunctions(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var created []*Function
+	for _, function := range f.functions {
+		if !function.Reused && !function.Removed {
+			created = append(created, function)
+		}
+	}
+	if len(created) == 0 {
+		return nil
+	}
+	if f.remover == nil {
+		return fmt.Errorf("the Leaf API cannot remove functions, %d functions are left to the Leaf", len(created))
+	}
+
+	var errs []error
+	for _, function := range created {
+		if err := f.remover.RemoveFunction(ctx, function.ID); err != nil {
+			errs = append(errs, fmt.Errorf("function %s: %w", function.ID, err))
+			continue
+		}
+		function.Removed = true
+	}
+	return errors.Join(errs...)
+}
+
+// FunctionRecord is a function the run sent calls to, as recorded in the run metadata.
+type FunctionRecord struct {
+	ID       string `json:"id"`
+	ImageTag string `json:"image_tag"`
+	Reused   bool   `json:"reused"`  // taken from the config instead of created by the run
+	Removed  bool   `json:"removed"` // removed at the end of the run
+	// resources the run created the function with, unknown for reused functions
+	Memory int64 `json:"memory_bytes,omitempty"`
+	Cpu    *CPU  `json:"cpu,omitempty"`
+}
+
+// Functions returns the functions of the run by image tag and ID.
+func (f *FunctionManager) Functions() []FunctionRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records := make([]FunctionRecord, 0, len(f.functions))
+	for _, function := range f.functions {
+		record := FunctionRecord{
+			ID:       function.ID,
+			ImageTag: function.ImageTag,
+			Reused:   function.Reused,
+			Removed:  function.Removed,
+		}
+		if config := function.ProtoConfig; config != nil {
+			record.Memory = config.Memory
+			if config.Cpu != nil {
+				record.Cpu = &CPU{Period: config.Cpu.Period, Quota: config.Cpu.Quota}
+			}
+		}
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(a, b FunctionRecord) int {
+		return cmp.Or(cmp.Compare(a.ImageTag, b.ImageTag), cmp.Compare(a.ID, b.ID))
+	})
+	return records
+}
+
+// memoryUnits are the suffixes of Kubernetes quantities, binary before decimal.
+var memoryUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
 
-	var multiplier int64
-	var numStr string
+// convertMemory converts a memory size to bytes. It accepts Kubernetes
+// quantities (512Mi, 1.5Gi, 500M, plain bytes) as well as MB and GB in any case,
+// which count in powers of 1024 like Mi and Gi.
+func convertMemory(memory string) (int64, error) {
+	memory = strings.TrimSpace(memory)
 
-	if strings.HasSuffix(memory, "GB") {
-		multiplier = 1024 * 1024 * 1024 // 1 GB in bytes
-		numStr = strings.TrimSuffix(memory, "GB")
-	} else if strings.HasSuffix(memory, "MB") {
-		multiplier = 1024 * 1024 // 1 MB in bytes
-		numStr = strings.TrimSuffix(memory, "MB")
-	} else {
-		return 0, fmt.Errorf("unsupported memory format: %s (expected format like '256MB' or '1GB')", memory)
+	numStr, multiplier := memory, 1.0
+	switch upper := strings.ToUpper(memory); {
+	case strings.HasSuffix(upper, "GB"):
+		numStr, multiplier = memory[:len(memory)-2], 1<<30
+	case strings.HasSuffix(upper, "MB"):
+		numStr, multiplier = memory[:len(memory)-2], 1<<20
+	default:
+		for _, unit := range memoryUnits {
+			if n, ok := strings.CutSuffix(memory, unit.suffix); ok {
+				numStr, multiplier = n, unit.multiplier
+				break
+			}
+		}
 	}
 
 	num, err := strconv.ParseFloat(numStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid memory value: %s", memory)
+	if err != nil || math.IsInf(num, 0) || math.IsNaN(num) || num <= 0 {
+		return 0, fmt.Errorf("invalid memory %q, expected a quantity like 512Mi, 1.5Gi or 256MB", memory)
 	}
 
-	totalBytes := int64(num * float64(multiplier))
+	totalBytes := int64(num * multiplier)
 
 	minBytes := int64(6 * 1024 * 1024)
 	if totalBytes < minBytes {
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestFunctionConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *FunctionConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"replicas", &FunctionConfig{Replicas: 3}, false},
+		{"function ids", &FunctionConfig{FunctionIDs: []string{"a", "b"}}, false},
+		{"both", &FunctionConfig{Replicas: 2, FunctionIDs: []string{"a"}}, true},
+		{"negative replicas", &FunctionConfig{Replicas: -1}, true},
+		{"negative call timeout", &FunctionConfig{CallTimeout: -time.Second}, true},
+		{"partial", &FunctionConfig{Cpu: &CPU{Quota: 20000}}, false},
+		{"memory", &FunctionConfig{Memory: "1.5GB", Cpu: &CPU{Period: 100000, Quota: 200000}}, false},
+		{"memory without unit", &FunctionConfig{Memory: "256"}, true},
+		{"too little memory", &FunctionConfig{Memory: "4MB"}, true},
+		{"cpu period too short", &FunctionConfig{Cpu: &CPU{Period: 500, Quota: 50000}}, true},
+		{"cpu period too long", &FunctionConfig{Cpu: &CPU{Period: 2000000}}, true},
+		{"cpu quota too small", &FunctionConfig{Cpu: &CPU{Period: 100000, Quota: 10}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestResolveFunctionConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		imageTag string
+		defaults *FunctionConfig
+		config   *FunctionConfig
+		want     FunctionConfig
+	}{
+		{"built-in", "hyperfaas-thumbnailer-json:latest", nil, nil, FunctionConfig{Memory: "1024MB", Cpu: &CPU{Period: 100000, Quota: 100000}}},
+		{"unknown tag", "custom:v1", nil, nil, FunctionConfig{Memory: "256MB", Cpu: &CPU{Period: 100000, Quota: 50000}}},
+		{
+			"defaults",
+			"hyperfaas-echo:latest",
+			&FunctionConfig{Memory: "128MB", CallTimeout: time.Second},
+			nil,
+			FunctionConfig{Memory: "128MB", Cpu: &CPU{Period: 100000, Quota: 50000}, CallTimeout: time.Second},
+		},
+		{
+			"function config over defaults",
+			"hyperfaas-echo:latest",
+			&FunctionConfig{Memory: "128MB", Cpu: &CPU{Quota: 25000}, Replicas: 2},
+			&FunctionConfig{Memory: "512MB", Cpu: &CPU{Period: 50000}},
+			FunctionConfig{Memory: "512MB", Cpu: &CPU{Period: 50000, Quota: 25000}, Replicas: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveFunctionConfig(tt.imageTag, tt.defaults, tt.config)
+			if got.Memory != tt.want.Memory || got.Cpu.Period != tt.want.Cpu.Period || got.Cpu.Quota != tt.want.Cpu.Quota ||
+				got.CallTimeout != tt.want.CallTimeout || got.Replicas != tt.want.Replicas {
+				t.Errorf("Expected %+v with cpu %+v, got %+v with cpu %+v", tt.want, *tt.want.Cpu, *got, *got.Cpu)
+			}
+		})
+	}
+}
+
+func TestNewController_WithoutFunctionConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+leaf_address: localhost:50050
+max_duration: 10s
+timeout: 10
+defaults:
+  memory: 128MB
+workload:
+  phases:
+    - {name: echo, type: constant, start_rps: 10, duration: 1s, image_tag: "hyperfaas-echo:latest"}
+    - {name: custom, type: constant, start_rps: 10, duration: 1s, image_tag: "custom:v1"}
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := NewController(logger, WithConfigFile(path))
+	for _, imageTag := range getDistinctImageTags(c.Config.Workload.Phases) {
+		if fc := c.Config.FunctionConfig[imageTag]; fc == nil || fc.Memory != "128MB" || fc.Cpu == nil {
+			t.Errorf("Expected a complete function config for %s, got %+v", imageTag, fc)
+		}
+		if c.GetDataProvider(imageTag) == nil {
+			t.Errorf("Expected a data provider for %s", imageTag)
+		}
+	}
+}
+
+func TestController_CreateFunctions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fake := NewFakeLeaf(nil, logger)
+	address, err := fake.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcMgr := NewFunctionManager(address, nil, insecure.NewCredentials())
+	funcMgr.remover = fake
+	c := &Controller{
+		funcMgr: funcMgr,
+		Config: &Config{
+			FunctionConfig: map[string]*FunctionConfig{
+				"echo":  {Memory: "64MB", Replicas: 3},
+				"bfs":   {FunctionIDs: []string{"existing-1", "existing-2"}},
+				"thumb": {Memory: "64MB"},
+			},
+			Workload: &Workload{Phases: []TestPhase{
+				{Name: "echo-1", ImageTag: "echo"},
+				{Name: "echo-2", ImageTag: "echo"},
+				{Name: "bfs", ImageTag: "bfs"},
+				{Name: "pinned", ImageTag: "thumb", FunctionID: "existing-3"},
+			}},
+		},
+	}
+	c.CreateFunctions()
+
+	phases := c.Config.Workload.Phases
+	if len(phases[0].FunctionIDs) != 3 || phases[0].FunctionIDs[0] == phases[0].FunctionIDs[1] {
+		t.Errorf("Expected 3 distinct echo functions, got %v", phases[0].FunctionIDs)
+	}
+	if phases[1].FunctionID != phases[0].FunctionID || len(phases[1].FunctionIDs) != 3 {
+		t.Errorf("Expected the echo phases to share their functions, got %v and %v", phases[0].FunctionIDs, phases[1].FunctionIDs)
+	}
+	if phases[2].FunctionID != "existing-1" || len(phases[2].FunctionIDs) != 2 {
+		t.Errorf("Expected the configured bfs functions, got %v", phases[2].FunctionIDs)
+	}
+	if phases[3].FunctionID != "existing-3" || len(phases[3].FunctionIDs) != 0 {
+		t.Errorf("Expected the pinned phase to keep its function, got %s %v", phases[3].FunctionID, phases[3].FunctionIDs)
+	}
+
+	seen := make(map[string]int)
+	phase := phases[0]
+	for range 300 {
+		seen[phase.callFunctionID()]++
+	}
+	for _, id := range phase.FunctionIDs {
+		if seen[id] < 50 {
+			t.Errorf("Expected the calls to be spread over all functions, got %v", seen)
+		}
+	}
+
+	if err := funcMgr.RemoveFunctions(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	removed, reused := 0, 0
+	for _, f := range funcMgr.Functions() {
+		if f.Removed {
+			removed++
+		}
+		if f.Reused {
+			reused++
+			if f.Removed {
+				t.Errorf("Expected reused function %s to be kept", f.ID)
+			}
+		}
+	}
+	if removed != 3 || reused != 3 {
+		t.Errorf("Expected 3 removed and 3 reused functions, got %d and %d", removed, reused)
+	}
+	if err := fake.RemoveFunction(t.Context(), phase.FunctionID); err == nil {
+		t.Errorf("Expected %s to be gone from the Leaf", phase.FunctionID)
+	}
+}
+
+func TestController_RemoveFunctionsAfterRun(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "results.csv")
+	config := &Config{
+		MaxDuration: 10 * time.Second,
+		Timeout:     10,
+		Cleanup:     CleanupRemove,
+		FunctionConfig: map[string]*FunctionConfig{
+			"hyperfaas-echo:latest": {Memory: "64MB", Replicas: 2},
+		},
+		Workload: &Workload{Phases: []TestPhase{
+			{Name: "steady", Type: "constant", StartRPS: 10, Duration: 500 * time.Millisecond, ImageTag: "hyperfaas-echo:latest"},
+		}},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	NewController(logger, WithConfig(config), WithFakeLeaf(), WithCollector(NewCollector(out, ""))).Run(t.Context())
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(out), "results.meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Functions) != 2 {
+		t.Fatalf("Expected 2 functions in the metadata, got %+v", meta.Functions)
+	}
+	for _, f := range meta.Functions {
+		if f.ImageTag != "hyperfaas-echo:latest" || f.Reused || !f.Removed {
+			t.Errorf("Expected a created and removed echo function, got %+v", f)
+		}
+	}
+}
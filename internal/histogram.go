@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// histogram values are nanoseconds, tracked from 1µs up to 10 minutes with 3 significant digits
+	histogramMin    = int64(time.Microsecond)
+	histogramMax    = int64(10 * time.Minute)
+	histogramDigits = 3
+
+	// defaultHistogramInterval is the length of each histogram window.
+	defaultHistogramInterval = 10 * time.Second
+)
+
+// HistogramKey identifies the calls that share a histogram.
+type HistogramKey struct {
+	Phase    string
+	ImageTag string
+	Status   codes.Code
+}
+
+// LatencyHistogram holds the latencies of one key. Latency is the service time
+// from sending the call to its response. Corrected is measured from the intended
+// send time instead, so calls that were held back by an overloaded generator or
+// target count their waiting time too and coordinated omission does not hide it.
+type LatencyHistogram struct {
+	Latency   *hdrhistogram.Histogram
+	Corrected *hdrhistogram.Histogram
+}
+
+func newLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{
+		Latency:   hdrhistogram.New(histogramMin, histogramMax, histogramDigits),
+		Corrected: hdrhistogram.New(histogramMin, histogramMax, histogramDigits),
+	}
+}
+
+// HistogramSet maps each key to its histograms, for one window or a whole run.
+type HistogramSet map[HistogramKey]*LatencyHistogram
+
+// Record adds a call result. Dropped calls and the interrupt marker have no
+// latency and are skipped.
+func (s HistogramSet) Record(result CallResult) {
+	if result.Outcome == OutcomeDropped || result.Outcome == OutcomeInterrupted {
+		return
+	}
+	key := HistogramKey{Phase: result.Phase, ImageTag: result.ImageTag, Status: result.Status}
+	h, ok := s[key]
+	if !ok {
+		h = newLatencyHistogram()
+		s[key] = h
+	}
+	h.Latency.RecordValue(clampHistogramValue(result.Latency))
+	// the corrected latency runs from the schedule to the response of the last attempt
+	corrected := result.Latency
+	if !result.IntendedTimestamp.IsZero() {
+		corrected += max(result.Timestamp.Sub(result.IntendedTimestamp), 0)
+	}
+	h.Corrected.RecordValue(clampHistogramValue(corrected))
+}
+
+// Merge adds all histograms of other to s.
+func (s HistogramSet) Merge(other HistogramSet) {
+	for key, from := range other {
+		h, ok := s[key]
+		if !ok {
+			h = newLatencyHistogram()
+			s[key] = h
+		}
+		h.Latency.Merge(from.Latency)
+		h.Corrected.Merge(from.Corrected)
+	}
+}
+
+// Filter returns a merged histogram of all keys for which match is true, e.g.
+// all statuses of one phase.
+func (s HistogramSet) Filter(match func(HistogramKey) bool) *LatencyHistogram {
+	merged := newLatencyHistogram()
+	for key, h := range s {
+		if match(key) {
+			merged.Latency.Merge(h.Latency)
+			merged.Corrected.Merge(h.Corrected)
+		}
+	}
+	return merged
+}
+
+// summarizeHistogram reads the percentiles of a latency histogram.
+func summarizeHistogram(h *hdrhistogram.Histogram) LatencySummary {
+	if h.TotalCount() == 0 {
+		return LatencySummary{}
+	}
+	at := func(q float64) time.Duration {
+		return time.Duration(h.ValueAtQuantile(q)).Round(time.Microsecond)
+	}
+	return LatencySummary{
+		P50:  at(50),
+		P90:  at(90),
+		P95:  at(95),
+		P99:  at(99),
+		P999: at(99.9),
+		Max:  time.Duration(h.Max()).Round(time.Microsecond),
+	}
+}
+
+// clampHistogramValue keeps latencies beyond the tracked range in the histogram
+// instead of dropping them.
+func clampHistogramValue(d time.Duration) int64 {
+	return min(max(int64(d), histogramMin), histogramMax)
+}
+
+// histogramLog writes windows to an HdrHistogram interval log (format 1.3), which
+// HistogramLogProcessor, HdrHistogramVisualizer and friends can read. Each window
+// writes one tagged line per key and latency kind.
+type histogramLog struct {
+	out   io.Writer
+	start time.Time
+}
+
+func newHistogramLog(out io.Writer, start time.Time) (*histogramLog, error) {
+	w := hdrhistogram.NewHistogramLogWriter(out)
+	if err := w.OutputLogFormatVersion(); err != nil {
+		return nil, err
+	}
+	if err := w.OutputStartTime(start.UnixMilli()); err != nil {
+		return nil, err
+	}
+	if err := w.OutputLegend(); err != nil {
+		return nil, err
+	}
+	return &histogramLog{out: out, start: start}, nil
+}
+
+// writeWindow writes the histograms of the window from start to end. The lines
+// are written here rather than by HistogramLogWriter, which puts the end time
+// where the format expects the interval length.
+func (l *histogramLog) writeWindow(set HistogramSet, start, end time.Time) error {
+	keys := slices.SortedFunc(maps.Keys(set), func(a, b HistogramKey) int {
+		return cmp.Or(cmp.Compare(a.Phase, b.Phase), cmp.Compare(a.ImageTag, b.ImageTag), cmp.Compare(a.Status, b.Status))
+	})
+	for _, key := range keys {
+		h := set[key]
+		for _, kind := range []struct {
+			name string
+			hist *hdrhistogram.Histogram
+		}{{"latency", h.Latency}, {"corrected", h.Corrected}} {
+			hist := kind.hist
+			payload, err := hist.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(l.out, "Tag=%s,%.3f,%.3f,%.3f,%s\n",
+				histogramTag(key, kind.name),
+				start.Sub(l.start).Seconds(),
+				end.Sub(start).Seconds(),
+				float64(hist.Max())/float64(time.Millisecond),
+				payload)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// histogramTag builds a log tag like phase=warmup;image_tag=echo:latest;status=OK;kind=latency.
+// Tags must not contain commas or whitespace.
+func histogramTag(key HistogramKey, kind string) string {
+	clean := strings.NewReplacer(",", "_", " ", "_", "\t", "_", "\r", "_", "\n", "_", ";", "_")
+	return fmt.Sprintf("phase=%s;image_tag=%s;status=%s;kind=%s",
+		clean.Replace(key.Phase), clean.Replace(key.ImageTag), key.Status, kind)
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"google.golang.org/grpc/codes"
+)
+
+func TestHistogramSet_Record(t *testing.T) {
+	set := make(HistogramSet)
+	now := time.Now()
+	for i := 1; i <= 1000; i++ {
+		set.Record(CallResult{Phase: "steady", ImageTag: "echo:latest", Latency: time.Duration(i) * time.Millisecond, Timestamp: now, IntendedTimestamp: now, Outcome: OutcomeSent})
+	}
+	// held back for a second by the limiter
+	set.Record(CallResult{Phase: "steady", ImageTag: "echo:latest", Latency: time.Millisecond, Timestamp: now.Add(time.Second), IntendedTimestamp: now, Outcome: OutcomeLate})
+	set.Record(CallResult{Phase: "steady", ImageTag: "echo:latest", Status: codes.ResourceExhausted, Outcome: OutcomeDropped})
+	set.Record(CallResult{Phase: "steady", ImageTag: "echo:latest", Status: codes.Unavailable, Latency: time.Hour, Outcome: OutcomeSent})
+
+	ok := set[HistogramKey{Phase: "steady", ImageTag: "echo:latest", Status: codes.OK}]
+	if ok == nil || ok.Latency.TotalCount() != 1001 {
+		t.Fatalf("Expected 1001 OK latencies, got %v", ok)
+	}
+	if p99 := time.Duration(ok.Latency.ValueAtQuantile(99)); p99 < 989*time.Millisecond || p99 > 991*time.Millisecond {
+		t.Errorf("Expected p99 of about 990ms, got %v", p99)
+	}
+	if maxLatency, maxCorrected := time.Duration(ok.Latency.Max()), time.Duration(ok.Corrected.Max()); maxLatency > 1001*time.Millisecond || maxCorrected < time.Second {
+		t.Errorf("Expected only the corrected histogram to include the send delay, got max %v and %v", maxLatency, maxCorrected)
+	}
+	if _, found := set[HistogramKey{Phase: "steady", ImageTag: "echo:latest", Status: codes.ResourceExhausted}]; found {
+		t.Errorf("Expected dropped calls not to be recorded")
+	}
+	if unavailable := set[HistogramKey{Phase: "steady", ImageTag: "echo:latest", Status: codes.Unavailable}]; time.Duration(unavailable.Latency.Max()) < 9*time.Minute {
+		t.Errorf("Expected latencies beyond the range to be clamped, got max %v", time.Duration(unavailable.Latency.Max()))
+	}
+
+	all := set.Filter(func(key HistogramKey) bool { return key.Phase == "steady" })
+	if all.Latency.TotalCount() != 1002 {
+		t.Errorf("Expected 1002 latencies across statuses, got %d", all.Latency.TotalCount())
+	}
+}
+
+func TestHistogramSet_Merge(t *testing.T) {
+	first, second := make(HistogramSet), make(HistogramSet)
+	first.Record(CallResult{Phase: "a", Latency: time.Millisecond})
+	second.Record(CallResult{Phase: "a", Latency: 2 * time.Millisecond})
+	second.Record(CallResult{Phase: "b", Latency: 3 * time.Millisecond})
+
+	merged := make(HistogramSet)
+	merged.Merge(first)
+	merged.Merge(second)
+	if n := merged[HistogramKey{Phase: "a"}].Latency.TotalCount(); n != 2 {
+		t.Errorf("Expected 2 latencies for phase a, got %d", n)
+	}
+	if n := merged[HistogramKey{Phase: "b"}].Latency.TotalCount(); n != 1 {
+		t.Errorf("Expected 1 latency for phase b, got %d", n)
+	}
+	if n := first[HistogramKey{Phase: "a"}].Latency.TotalCount(); n != 1 {
+		t.Errorf("Expected the merged set to be left alone, got %d latencies", n)
+	}
+}
+
+func TestCollector_HistogramLog(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCollector(filepath.Join(dir, "results.csv"), "")
+	hlogFile := filepath.Join(dir, "results.hlog")
+	if err := c.WriteHistogramLog(hlogFile, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	c.Collect(CallResult{Phase: "steady", ImageTag: "echo:latest", Latency: 5 * time.Millisecond, Outcome: OutcomeSent})
+	c.Collect(CallResult{Phase: "steady", ImageTag: "echo:latest", Latency: 7 * time.Millisecond, Status: codes.Internal, Outcome: OutcomeSent})
+	c.Close()
+	if n := len(c.Histograms()); n != 2 {
+		t.Errorf("Expected histograms for 2 keys, got %d", n)
+	}
+
+	data, err := os.ReadFile(hlogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Tag=phase=steady;image_tag=echo:latest;status=Internal;kind=corrected,") {
+		t.Errorf("Expected a tagged line for the Internal errors:\n%s", data)
+	}
+
+	reader := hdrhistogram.NewHistogramLogReader(bytes.NewReader(data))
+	var total int64
+	for {
+		h, err := reader.NextIntervalHistogram()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h == nil {
+			break
+		}
+		if strings.HasSuffix(h.Tag(), "kind=latency") {
+			total += h.TotalCount()
+		}
+	}
+	if total != 2 {
+		t.Errorf("Expected 2 latencies in the log, got %d", total)
+	}
+}
@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// InstanceTracker is a RunObserver that follows the function instances named in
+// the instanceId trailer. The first call that reaches an instance is flagged as
+// a cold start. Instances that were already running before the run started are
+// counted as cold on their first call too. Calls are ordered by the time the
+// Leaf scheduled them, not by the order in which their results arrive: a warm
+// call may finish before the call that started its instance.
+type InstanceTracker struct {
+	bucket time.Duration
+
+	mu        sync.Mutex
+	start     time.Time
+	instances map[string]*instanceInfo
+	byTag     map[string]*instanceStats
+}
+
+type instanceInfo struct {
+	imageTag  string
+	firstSeen time.Time
+	lastSeen  time.Time
+	calls     int64
+	// the cold start so far: when it was scheduled, its latency and spawn bucket
+	coldAt      time.Time
+	coldLatency time.Duration
+	coldBucket  int
+}
+
+type instanceStats struct {
+	calls int64
+	// warmLatency holds the latencies of all calls but the cold starts, which are
+	// kept per instance until no earlier call can arrive
+	warmLatency *hdrhistogram.Histogram
+	// spawned counts the new instances per bucket since the start of the run
+	spawned []int
+}
+
+// NewInstanceTracker counts new instances in buckets of the given length.
+func NewInstanceTracker(bucket time.Duration) *InstanceTracker {
+	return &InstanceTracker{
+		bucket:    bucket,
+		start:     time.Now(),
+		instances: make(map[string]*instanceInfo),
+		byTag:     make(map[string]*instanceStats),
+	}
+}
+
+func (t *InstanceTracker) RunStarted(phases []TestPhase) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.start = time.Now()
+}
+
+func (t *InstanceTracker) PhaseStarted(phase TestPhase)  {}
+func (t *InstanceTracker) PhaseFinished(phase TestPhase) {}
+func (t *InstanceTracker) CallStarted(phase TestPhase)   {}
+func (t *InstanceTracker) Collect(result CallResult)     {}
+
+// Mark flags result as a cold start if it is the first call that reached its
+// instance and records it.
+func (t *InstanceTracker) Mark(result *CallResult) {
+	if result.InstanceID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := result.Timestamp.Add(result.Latency)
+	scheduled := result.LeafScheduledCallTimestamp
+	if scheduled.IsZero() {
+		scheduled = result.Timestamp
+	}
+	stats, ok := t.byTag[result.ImageTag]
+	if !ok {
+		stats = &instanceStats{
+			warmLatency: hdrhistogram.New(histogramMin, histogramMax, histogramDigits),
+		}
+		t.byTag[result.ImageTag] = stats
+	}
+	stats.calls++
+
+	instance, ok := t.instances[result.InstanceID]
+	switch {
+	case !ok:
+		instance = &instanceInfo{imageTag: result.ImageTag, firstSeen: seen}
+		t.instances[result.InstanceID] = instance
+	case scheduled.Before(instance.coldAt):
+		// the call that started the instance finished after a warm call to it
+		stats.warmLatency.RecordValue(clampHistogramValue(instance.coldLatency))
+		stats.spawned[instance.coldBucket]--
+	default:
+		stats.warmLatency.RecordValue(clampHistogramValue(result.Latency))
+		instance.calls++
+		instance.lastSeen = later(instance.lastSeen, seen)
+		return
+	}
+
+	result.ColdStart = true
+	instance.coldAt = scheduled
+	instance.coldLatency = result.Latency
+	instance.coldBucket = 0
+	if t.bucket > 0 {
+		instance.coldBucket = max(int(scheduled.Sub(t.start)/t.bucket), 0)
+	}
+	for len(stats.spawned) <= instance.coldBucket {
+		stats.spawned = append(stats.spawned, 0)
+	}
+	stats.spawned[instance.coldBucket]++
+	instance.calls++
+	instance.firstSeen = earlier(instance.firstSeen, seen)
+	instance.lastSeen = later(instance.lastSeen, seen)
+}
+
+func earlier(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// InstanceReport describes the instances per image tag.
+type InstanceReport struct {
+	BucketSize time.Duration       `json:"bucket_size_ns"`
+	ImageTags  []InstanceTagReport `json:"image_tags"`
+}
+
+type InstanceTagReport struct {
+	ImageTag      string         `json:"image_tag"`
+	Instances     int            `json:"instances"`
+	Calls         int64          `json:"calls"`
+	ColdStarts    int64          `json:"cold_starts"`
+	ColdStartRate float64        `json:"cold_start_rate"`
+	ColdLatency   LatencySummary `json:"cold_latency"`
+	WarmLatency   LatencySummary `json:"warm_latency"`
+	// Spawned is the number of new instances in each bucket since the start of the run
+	Spawned []int `json:"spawned"`
+	// calls per instance
+	MinReuse    int64           `json:"min_calls_per_instance"`
+	MedianReuse int64           `json:"median_calls_per_instance"`
+	MaxReuse    int64           `json:"max_calls_per_instance"`
+	PerInstance []InstanceUsage `json:"per_instance"`
+}
+
+type InstanceUsage struct {
+	InstanceID string    `json:"instance_id"`
+	Calls      int64     `json:"calls"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// Report builds the report from everything tracked so far.
+func (t *InstanceTracker) Report() InstanceReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := make(map[string][]InstanceUsage)
+	coldLatency := make(map[string]*hdrhistogram.Histogram)
+	for id, instance := range t.instances {
+		cold, ok := coldLatency[instance.imageTag]
+		if !ok {
+			cold = hdrhistogram.New(histogramMin, histogramMax, histogramDigits)
+			coldLatency[instance.imageTag] = cold
+		}
+		cold.RecordValue(clampHistogramValue(instance.coldLatency))
+		usage[instance.imageTag] = append(usage[instance.imageTag], InstanceUsage{
+			InstanceID: id,
+			Calls:      instance.calls,
+			FirstSeen:  instance.firstSeen,
+			LastSeen:   instance.lastSeen,
+		})
+	}
+
+	report := InstanceReport{BucketSize: t.bucket}
+	for _, tag := range slices.Sorted(maps.Keys(t.byTag)) {
+		stats := t.byTag[tag]
+		instances := usage[tag]
+		slices.SortFunc(instances, func(a, b InstanceUsage) int {
+			return a.FirstSeen.Compare(b.FirstSeen)
+		})
+
+		// every instance has exactly one cold start
+		row := InstanceTagReport{
+			ImageTag:    tag,
+			Instances:   len(instances),
+			Calls:       stats.calls,
+			ColdStarts:  int64(len(instances)),
+			ColdLatency: summarizeHistogram(coldLatency[tag]),
+			WarmLatency: summarizeHistogram(stats.warmLatency),
+			Spawned:     slices.Clone(stats.spawned),
+			PerInstance: instances,
+		}
+		if stats.calls > 0 {
+			row.ColdStartRate = float64(row.ColdStarts) / float64(stats.calls)
+		}
+		if len(instances) > 0 {
+			calls := make([]int64, len(instances))
+			for i, instance := range instances {
+				calls[i] = instance.Calls
+			}
+			slices.Sort(calls)
+			row.MinReuse = calls[0]
+			row.MedianReuse = calls[len(calls)/2]
+			row.MaxReuse = calls[len(calls)-1]
+		}
+		report.ImageTags = append(report.ImageTags, row)
+	}
+	return report
+}
+
+// WriteText prints the report as a table followed by the new instances over time.
+func (r InstanceReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE TAG\tINSTANCES\tCALLS\tCOLD STARTS\tCOLD RATE\tCOLD P50\tCOLD P99\tWARM P50\tWARM P99\tCALLS/INSTANCE (MIN/MEDIAN/MAX)")
+	for _, row := range r.ImageTags {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f%%\t%v\t%v\t%v\t%v\t%d/%d/%d\n",
+			row.ImageTag, row.Instances, row.Calls, row.ColdStarts, row.ColdStartRate*100,
+			row.ColdLatency.P50, row.ColdLatency.P99, row.WarmLatency.P50, row.WarmLatency.P99,
+			row.MinReuse, row.MedianReuse, row.MaxReuse)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nNew instances per %v:\n", r.BucketSize)
+	for _, row := range r.ImageTags {
+		counts := make([]string, len(row.Spawned))
+		for i, n := range row.Spawned {
+			counts[i] = fmt.Sprint(n)
+		}
+		fmt.Fprintf(w, "  %s: %s\n", row.ImageTag, strings.Join(counts, " "))
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r InstanceReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestInstanceTracker_Mark(t *testing.T) {
+	tracker := NewInstanceTracker(10 * time.Second)
+	tracker.RunStarted(nil)
+	start := time.Now()
+
+	calls := []struct {
+		instance string
+		offset   time.Duration
+		latency  time.Duration
+		cold     bool
+	}{
+		{"a", 0, 500 * time.Millisecond, true},
+		{"a", time.Second, 10 * time.Millisecond, false},
+		{"a", 2 * time.Second, 12 * time.Millisecond, false},
+		{"b", 15 * time.Second, 400 * time.Millisecond, true},
+		{"b", 16 * time.Second, 11 * time.Millisecond, false},
+		{"", 17 * time.Second, time.Millisecond, false}, // no trailer, not tracked
+	}
+	for _, call := range calls {
+		result := CallResult{ImageTag: "echo:latest", InstanceID: call.instance, Timestamp: start.Add(call.offset), Latency: call.latency}
+		tracker.Mark(&result)
+		if result.ColdStart != call.cold {
+			t.Errorf("Expected cold start %v for call to %q at %v, got %v", call.cold, call.instance, call.offset, result.ColdStart)
+		}
+	}
+
+	report := tracker.Report()
+	if len(report.ImageTags) != 1 {
+		t.Fatalf("Expected 1 image tag, got %d", len(report.ImageTags))
+	}
+	row := report.ImageTags[0]
+	if row.Instances != 2 || row.Calls != 5 || row.ColdStarts != 2 || row.ColdStartRate != 0.4 {
+		t.Errorf("Expected 2 instances, 5 calls and 2 cold starts, got %+v", row)
+	}
+	if len(row.Spawned) != 2 || row.Spawned[0] != 1 || row.Spawned[1] != 1 {
+		t.Errorf("Expected one new instance in each of the first two buckets, got %v", row.Spawned)
+	}
+	if row.MinReuse != 2 || row.MaxReuse != 3 {
+		t.Errorf("Expected 2 to 3 calls per instance, got %d to %d", row.MinReuse, row.MaxReuse)
+	}
+	if row.ColdLatency.P50 < 399*time.Millisecond || row.WarmLatency.Max > 13*time.Millisecond {
+		t.Errorf("Expected cold latencies around 400ms and warm ones up to 12ms, got %+v and %+v", row.ColdLatency, row.WarmLatency)
+	}
+	if row.PerInstance[0].InstanceID != "a" || row.PerInstance[0].Calls != 3 {
+		t.Errorf("Expected instance a first with 3 calls, got %+v", row.PerInstance[0])
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "echo:latest: 1 1") {
+		t.Errorf("Expected the spawn timeline in the output:\n%s", out.String())
+	}
+}
+
+func TestInstanceTracker_MarkOutOfOrder(t *testing.T) {
+	tracker := NewInstanceTracker(10 * time.Second)
+	tracker.RunStarted(nil)
+	start := time.Now()
+
+	// the warm call was scheduled after the cold start but finished first
+	warm := CallResult{ImageTag: "echo:latest", InstanceID: "a", Timestamp: start, LeafScheduledCallTimestamp: start.Add(300 * time.Millisecond), Latency: 310 * time.Millisecond}
+	cold := CallResult{ImageTag: "echo:latest", InstanceID: "a", Timestamp: start, LeafScheduledCallTimestamp: start.Add(time.Millisecond), Latency: 500 * time.Millisecond}
+	tracker.Mark(&warm)
+	tracker.Mark(&cold)
+	if !cold.ColdStart {
+		t.Errorf("Expected the call scheduled first to be the cold start")
+	}
+
+	row := tracker.Report().ImageTags[0]
+	if row.Instances != 1 || row.ColdStarts != 1 || row.Calls != 2 || row.Spawned[0] != 1 {
+		t.Errorf("Expected 1 instance with 1 cold start of 2 calls, got %+v", row)
+	}
+	if !nearly(row.ColdLatency.P50, 500*time.Millisecond) || !nearly(row.WarmLatency.Max, 310*time.Millisecond) {
+		t.Errorf("Expected a cold latency of 500ms and a warm one of 310ms, got %+v and %+v", row.ColdLatency, row.WarmLatency)
+	}
+}
+
+func TestCollector_MarksBeforeObserving(t *testing.T) {
+	tracker := NewInstanceTracker(time.Second)
+	summary := NewSummary()
+	c := NewCollector(t.TempDir()+"/results.csv", "")
+	c.observe([]RunObserver{summary, tracker})
+
+	c.Collect(CallResult{Phase: "p", ImageTag: "echo:latest", InstanceID: "a", Outcome: OutcomeSent, Status: codes.OK})
+	c.Collect(CallResult{Phase: "p", ImageTag: "echo:latest", InstanceID: "a", Outcome: OutcomeSent, Status: codes.OK})
+	c.Close()
+
+	summary.RunStarted([]TestPhase{{Name: "p"}})
+	if cold := summary.Report().ImageTags[0].ColdStarts; cold != 1 {
+		t.Errorf("Expected the summary to see 1 cold start, got %d", cold)
+	}
+}
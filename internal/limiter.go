@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	// OverloadDrop drops calls when no in-flight slot is free.
+	OverloadDrop = "drop"
+	// OverloadQueue parks up to QueueSize calls until a slot frees up and drops the rest.
+	OverloadQueue = "queue"
+	// OverloadBlock holds back the executor until a slot frees up, delaying every later call.
+	OverloadBlock = "block"
+)
+
+var ErrDropped = errors.New("dropped by in-flight limiter")
+
+// InFlightLimiter caps the number of calls that are in flight at the same time.
+// A phase limiter shares the slots of the global limiter, so a call needs a free
+// slot at both levels, but counts its own dropped and late calls. A nil limiter
+// does not limit anything.
+type InFlightLimiter struct {
+	policy    string
+	queueSize int64
+	// slots are acquired in order: the phase's own slots first, then the global ones
+	slots []chan struct{}
+	// queued is shared with the global limiter by phases that use its queue
+	queued *atomic.Int64
+
+	dropped atomic.Int64
+	late    atomic.Int64
+}
+
+// NewInFlightLimiter returns a limiter for at most maxInFlight concurrent calls,
+// or nil if maxInFlight is 0.
+func NewInFlightLimiter(maxInFlight int, policy string, queueSize int) *InFlightLimiter {
+	if maxInFlight <= 0 {
+		return nil
+	}
+	return &InFlightLimiter{
+		policy:    defaultPolicy(policy),
+		queueSize: int64(queueSize),
+		slots:     []chan struct{}{make(chan struct{}, maxInFlight)},
+		queued:    new(atomic.Int64),
+	}
+}
+
+// ForPhase returns a limiter for a single phase that additionally respects the
+// slots of l. An empty policy inherits the policy of l, and a phase that
+// inherits both policy and queue size waits in the queue of l.
+func (l *InFlightLimiter) ForPhase(maxInFlight int, policy string, queueSize int) *InFlightLimiter {
+	if l == nil {
+		return NewInFlightLimiter(maxInFlight, policy, queueSize)
+	}
+
+	phase := &InFlightLimiter{
+		policy:    cmp.Or(policy, l.policy),
+		queueSize: int64(cmp.Or(queueSize, int(l.queueSize))),
+		queued:    new(atomic.Int64),
+	}
+	if policy == "" && queueSize == 0 {
+		phase.queued = l.queued
+	}
+	if maxInFlight > 0 {
+		phase.slots = append(phase.slots, make(chan struct{}, maxInFlight))
+	}
+	phase.slots = append(phase.slots, l.slots...)
+	return phase
+}
+
+// Go runs call in a new goroutine once it holds a slot. call is told whether it
+// had to wait for the slot. If the call is never sent, drop is called instead,
+// with ErrDropped if the policy dropped it or the context error if ctx ended
+// while it waited. Both may be called after Go has returned.
+func (l *InFlightLimiter) Go(ctx context.Context, call func(waited bool), drop func(err error)) {
+	if l == nil {
+		go call(false)
+		return
+	}
+
+	if l.tryAcquire() {
+		go func() {
+			defer l.release()
+			call(false)
+		}()
+		return
+	}
+
+	switch l.policy {
+	case OverloadDrop:
+		l.dropped.Add(1)
+		drop(ErrDropped)
+	case OverloadQueue:
+		if l.queued.Add(1) > l.queueSize {
+			l.queued.Add(-1)
+			l.dropped.Add(1)
+			drop(ErrDropped)
+			return
+		}
+		go func() {
+			err := l.acquire(ctx)
+			l.queued.Add(-1)
+			if err != nil {
+				l.dropped.Add(1)
+				drop(err)
+				return
+			}
+			defer l.release()
+			l.late.Add(1)
+			call(true)
+		}()
+	default:
+		if err := l.acquire(ctx); err != nil {
+			l.dropped.Add(1)
+			drop(err)
+			return
+		}
+		l.late.Add(1)
+		go func() {
+			defer l.release()
+			call(true)
+		}()
+	}
+}
+
+// Do runs call synchronously once it holds a slot, waiting for one regardless of
+// the policy. It is meant for closed-loop users, which cannot drop their own call.
+func (l *InFlightLimiter) Do(ctx context.Context, call func(waited bool)) error {
+	if l == nil {
+		call(false)
+		return nil
+	}
+
+	waited := false
+	if !l.tryAcquire() {
+		if err := l.acquire(ctx); err != nil {
+			return err
+		}
+		waited = true
+		l.late.Add(1)
+	}
+	defer l.release()
+	call(waited)
+	return nil
+}
+
+// Dropped is the number of calls this limiter did not send.
+func (l *InFlightLimiter) Dropped() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.dropped.Load()
+}
+
+// Late is the number of calls that had to wait for a slot.
+func (l *InFlightLimiter) Late() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.late.Load()
+}
+
+func (l *InFlightLimiter) tryAcquire() bool {
+	for i, slots := range l.slots {
+		select {
+		case slots <- struct{}{}:
+		default:
+			l.releaseFirst(i)
+			return false
+		}
+	}
+	return true
+}
+
+func (l *InFlightLimiter) acquire(ctx context.Context) error {
+	for i, slots := range l.slots {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			l.releaseFirst(i)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (l *InFlightLimiter) release() {
+	l.releaseFirst(len(l.slots))
+}
+
+func (l *InFlightLimiter) releaseFirst(n int) {
+	for _, slots := range l.slots[:n] {
+		<-slots
+	}
+}
+
+func defaultPolicy(policy string) string {
+	if policy == "" {
+		return OverloadBlock
+	}
+	return policy
+}
+
+// validateOverloadPolicy checks a policy and the size of the queue it would use.
+func validateOverloadPolicy(policy string, queueSize int) error {
+	switch policy {
+	case "", OverloadDrop, OverloadBlock:
+	case OverloadQueue:
+		if queueSize <= 0 {
+			return fmt.Errorf("overload policy %s requires a queue_size above 0", OverloadQueue)
+		}
+	default:
+		return fmt.Errorf("unsupported overload policy: %s (expected %s, %s or %s)", policy, OverloadDrop, OverloadQueue, OverloadBlock)
+	}
+	if queueSize < 0 {
+		return errors.New("queue size must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInFlightLimiter_Policies(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		queueSize       int
+		expectedDropped int64
+		expectedLate    int64
+	}{
+		{"drop", OverloadDrop, 0, 3, 0},
+		{"queue", OverloadQueue, 2, 1, 2},
+		{"block", OverloadBlock, 0, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewInFlightLimiter(2, tt.policy, tt.queueSize)
+			release := make(chan struct{})
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			inFlight, peak := 0, 0
+
+			call := func(waited bool) {
+				defer wg.Done()
+				mu.Lock()
+				inFlight++
+				peak = max(peak, inFlight)
+				mu.Unlock()
+				<-release
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}
+
+			// the first two calls hold both slots until released
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				limiter.Go(context.Background(), call, func(err error) {
+					t.Errorf("Expected call %d to be dispatched, got %v", i, err)
+				})
+			}
+
+			var dispatched sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				dispatched.Add(1)
+				go func() {
+					defer dispatched.Done()
+					limiter.Go(context.Background(), call, func(err error) {
+						if !errors.Is(err, ErrDropped) {
+							t.Errorf("Expected ErrDropped, got %v", err)
+						}
+						wg.Done()
+					})
+				}()
+			}
+
+			// let blocked and queued calls reach the limiter before freeing slots
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			dispatched.Wait()
+			wg.Wait()
+
+			if peak > 2 {
+				t.Errorf("Expected at most 2 calls in flight, got %d", peak)
+			}
+			if limiter.Dropped() != tt.expectedDropped {
+				t.Errorf("Expected %d dropped calls, got %d", tt.expectedDropped, limiter.Dropped())
+			}
+			if limiter.Late() != tt.expectedLate {
+				t.Errorf("Expected %d late calls, got %d", tt.expectedLate, limiter.Late())
+			}
+		})
+	}
+}
+
+func TestInFlightLimiter_PhaseSharesGlobalSlots(t *testing.T) {
+	global := NewInFlightLimiter(1, OverloadDrop, 0)
+	phaseA := global.ForPhase(5, "", 0)
+	phaseB := global.ForPhase(0, "", 0)
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	phaseA.Go(context.Background(), func(bool) {
+		<-release
+		close(done)
+	}, func(err error) {
+		t.Errorf("Expected first call to be dispatched, got %v", err)
+	})
+
+	var dropErr error
+	phaseB.Go(context.Background(), func(bool) {
+		t.Error("Expected call to be dropped while the global slot is taken")
+	}, func(err error) {
+		dropErr = err
+	})
+	if !errors.Is(dropErr, ErrDropped) {
+		t.Errorf("Expected ErrDropped, got %v", dropErr)
+	}
+	// each phase counts its own calls, even without overrides
+	if phaseA.Dropped() != 0 || phaseB.Dropped() != 1 || global.Dropped() != 0 {
+		t.Errorf("Expected the drop to count for phase B only, got %d, %d and %d", phaseA.Dropped(), phaseB.Dropped(), global.Dropped())
+	}
+	close(release)
+	<-done
+}
+
+func TestInFlightLimiter_NilIsUnlimited(t *testing.T) {
+	var limiter *InFlightLimiter
+	if limiter.ForPhase(0, "", 0) != nil {
+		t.Error("Expected no limiter without a global or phase limit")
+	}
+
+	done := make(chan bool)
+	limiter.Go(context.Background(), func(waited bool) { done <- waited }, func(err error) {
+		t.Errorf("Expected nil limiter to dispatch, got %v", err)
+	})
+	if <-done {
+		t.Error("Expected nil limiter to never wait")
+	}
+}
+
+func TestValidateOverloadPolicy(t *testing.T) {
+	tests := []struct {
+		policy    string
+		queueSize int
+		wantErr   bool
+	}{
+		{"", 0, false},
+		{OverloadBlock, 0, false},
+		{OverloadDrop, 0, false},
+		{OverloadQueue, 100, false},
+		{OverloadQueue, 0, true},
+		{OverloadDrop, -1, true},
+		{"reject", 0, true},
+	}
+	for _, tt := range tests {
+		if err := validateOverloadPolicy(tt.policy, tt.queueSize); (err != nil) != tt.wantErr {
+			t.Errorf("Expected error %v for %q with queue size %d, got %v", tt.wantErr, tt.policy, tt.queueSize, err)
+		}
+	}
+}
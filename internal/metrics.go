@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
+)
+
+// Metrics is a RunObserver that exposes the run as Prometheus metrics, so that
+// it can be graphed next to the metrics of HyperFaaS itself.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	latency            *prometheus.HistogramVec
+	calls              *prometheus.CounterVec
+	inFlight           *prometheus.GaugeVec
+	targetRPS          *prometheus.GaugeVec
+	queueTime          *prometheus.HistogramVec
+	leafSchedulingTime *prometheus.HistogramVec
+	processingTime     *prometheus.HistogramVec
+	// inFlightGauges caches the in-flight gauge of each phase and image tag, so
+	// that the call goroutines do not look it up in the vector
+	inFlightGauges sync.Map // inFlightKey -> prometheus.Gauge
+
+	mu      sync.Mutex
+	running map[string]runningPhase
+}
+
+type inFlightKey struct {
+	phase, imageTag string
+}
+
+type runningPhase struct {
+	phase   TestPhase
+	started time.Time
+}
+
+func NewMetrics() *Metrics {
+	buckets := prometheus.ExponentialBuckets(0.0005, 2, 16) // 0.5ms to ~16s
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lg_call_latency_seconds",
+			Help:    "Latency of ScheduleCall as seen by the load generator.",
+			Buckets: buckets,
+		}, []string{"phase", "image_tag", "status"}),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lg_calls_total",
+			Help: "Calls by image tag, function ID, status code and outcome (sent, late or dropped).",
+		}, []string{"image_tag", "function_id", "status", "outcome"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "lg_calls_in_flight",
+			Help: "Calls sent and not yet answered.",
+		}, []string{"phase", "image_tag"}),
+		targetRPS: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "lg_target_rps",
+			Help: "Rate a running phase aims for. Not set for trace and closed phases.",
+		}, []string{"phase", "type", "image_tag"}),
+		queueTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lg_queue_time_seconds",
+			Help:    "Time a call waited at the worker before the function processed it, from the HyperFaaS trailers.",
+			Buckets: buckets,
+		}, []string{"image_tag"}),
+		leafSchedulingTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lg_leaf_scheduling_time_seconds",
+			Help:    "Time between the leaf receiving and scheduling a call, from the HyperFaaS trailers.",
+			Buckets: buckets,
+		}, []string{"image_tag"}),
+		processingTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lg_function_processing_time_seconds",
+			Help:    "Function processing time reported in the HyperFaaS trailers.",
+			Buckets: buckets,
+		}, []string{"image_tag"}),
+		running: make(map[string]runningPhase),
+	}
+	m.registry.MustRegister(
+		m.latency, m.calls, m.inFlight, m.targetRPS,
+		m.queueTime, m.leafSchedulingTime, m.processingTime,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics for scraping.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WatchCollector exposes the backpressure on the collector's buffer.
+func (m *Metrics) WatchCollector(c *Collector) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lg_collector_buffered_results",
+			Help: "Results waiting in the collector buffer for the writer.",
+		}, func() float64 { return float64(c.Stats().Buffered) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lg_collector_buffer_capacity",
+			Help: "Size of the collector buffer.",
+		}, func() float64 { return float64(c.Stats().Capacity) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "lg_collector_blocked_total",
+			Help: "Results that had to wait because the collector buffer was full.",
+		}, func() float64 { return float64(c.Stats().Blocked) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "lg_collector_blocked_seconds_total",
+			Help: "Time call goroutines spent waiting for a full collector buffer.",
+		}, func() float64 { return c.Stats().BlockedTime.Seconds() }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "lg_collector_written_total",
+			Help: "Results written to the output file.",
+		}, func() float64 { return float64(c.Stats().Written) }),
+	)
+}
+
+// Run updates the target RPS gauges every second until ctx is done.
+func (m *Metrics) Run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			m.updateTargets(now)
+		}
+	}
+}
+
+func (m *Metrics) updateTargets(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.running {
+		if rps, ok := TargetRPS(r.phase, now.Sub(r.started)); ok {
+			m.targetRPS.WithLabelValues(r.phase.Name, r.phase.Type, r.phase.ImageTag).Set(rps)
+		}
+	}
+}
+
+func (m *Metrics) RunStarted(phases []TestPhase) {}
+
+func (m *Metrics) PhaseStarted(phase TestPhase) {
+	m.mu.Lock()
+	m.running[phase.Name] = runningPhase{phase: phase, started: time.Now()}
+	m.mu.Unlock()
+	m.updateTargets(time.Now())
+}
+
+func (m *Metrics) PhaseFinished(phase TestPhase) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, phase.Name)
+	m.targetRPS.DeleteLabelValues(phase.Name, phase.Type, phase.ImageTag)
+}
+
+func (m *Metrics) CallStarted(phase TestPhase) {
+	m.inFlightGauge(phase.Name, phase.ImageTag).Inc()
+}
+
+func (m *Metrics) inFlightGauge(phase, imageTag string) prometheus.Gauge {
+	key := inFlightKey{phase, imageTag}
+	if g, ok := m.inFlightGauges.Load(key); ok {
+		return g.(prometheus.Gauge)
+	}
+	g, _ := m.inFlightGauges.LoadOrStore(key, m.inFlight.WithLabelValues(phase, imageTag))
+	return g.(prometheus.Gauge)
+}
+
+func (m *Metrics) Collect(result CallResult) {
+	if result.Outcome == OutcomeInterrupted {
+		return
+	}
+	m.calls.WithLabelValues(result.ImageTag, result.FunctionID, result.Status.String(), result.Outcome).Inc()
+	if result.Outcome == OutcomeDropped {
+		return
+	}
+
+	m.inFlightGauge(result.Phase, result.ImageTag).Dec()
+	m.latency.WithLabelValues(result.Phase, result.ImageTag, result.Status.String()).Observe(result.Latency.Seconds())
+	if result.Status != codes.OK {
+		return
+	}
+
+	if processing := result.FunctionProcessingTime; processing > 0 {
+		m.processingTime.WithLabelValues(result.ImageTag).Observe(processing.Seconds())
+
+		if !result.CallQueuedTimestamp.IsZero() && !result.GotResponseTimestamp.IsZero() {
+			queued := result.GotResponseTimestamp.Sub(result.CallQueuedTimestamp) - processing
+			m.queueTime.WithLabelValues(result.ImageTag).Observe(max(queued, 0).Seconds())
+		}
+	}
+
+	if !result.LeafGotRequestTimestamp.IsZero() && !result.LeafScheduledCallTimestamp.IsZero() {
+		scheduling := result.LeafScheduledCallTimestamp.Sub(result.LeafGotRequestTimestamp)
+		m.leafSchedulingTime.WithLabelValues(result.ImageTag).Observe(max(scheduling, 0).Seconds())
+	}
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestMetrics_Collect(t *testing.T) {
+	m := NewMetrics()
+	phase := TestPhase{Name: "p1", Type: "constant", StartRPS: 42, Duration: time.Minute, ImageTag: "echo:latest"}
+	m.PhaseStarted(phase)
+	m.CallStarted(phase)
+	m.CallStarted(phase)
+
+	base := time.Unix(0, 1_000_000_000)
+	m.Collect(CallResult{
+		Phase:                      "p1",
+		ImageTag:                   "echo:latest",
+		FunctionID:                 "f1",
+		Latency:                    20 * time.Millisecond,
+		Outcome:                    OutcomeSent,
+		LeafGotRequestTimestamp:    base,
+		LeafScheduledCallTimestamp: base.Add(2 * time.Millisecond),
+		CallQueuedTimestamp:        base.Add(3 * time.Millisecond),
+		GotResponseTimestamp:       base.Add(15 * time.Millisecond),
+		FunctionProcessingTime:     10 * time.Millisecond,
+	})
+	m.Collect(CallResult{Phase: "p1", ImageTag: "echo:latest", FunctionID: "f1", Status: codes.ResourceExhausted, Outcome: OutcomeDropped})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	text := string(body)
+
+	for _, expected := range []string{
+		`lg_calls_total{function_id="f1",image_tag="echo:latest",outcome="sent",status="OK"} 1`,
+		`lg_calls_total{function_id="f1",image_tag="echo:latest",outcome="dropped",status="ResourceExhausted"} 1`,
+		`lg_calls_in_flight{image_tag="echo:latest",phase="p1"} 1`,
+		`lg_target_rps{image_tag="echo:latest",phase="p1",type="constant"} 42`,
+		`lg_call_latency_seconds_count{image_tag="echo:latest",phase="p1",status="OK"} 1`,
+		`lg_leaf_scheduling_time_seconds_sum{image_tag="echo:latest"} 0.002`,
+		`lg_queue_time_seconds_sum{image_tag="echo:latest"} 0.002`,
+		`lg_function_processing_time_seconds_sum{image_tag="echo:latest"} 0.01`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected metrics to contain %s", expected)
+		}
+	}
+
+	m.PhaseFinished(phase)
+	rec = httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ = io.ReadAll(rec.Body)
+	if strings.Contains(string(body), `lg_target_rps{`) {
+		t.Error("Expected target RPS gauge to be removed when the phase finished")
+	}
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// RunObserver follows a run next to the Collector, for example to show live
+// statistics. The run and phase events may be called concurrently. CallStarted
+// is called by the call goroutines and must not take a lock that other calls
+// share. Collect is called by the collector's writer goroutine, one result at a
+// time, after the result was written.
+type RunObserver interface {
+	// RunStarted is called once with all phases after the functions were created.
+	RunStarted(phases []TestPhase)
+	PhaseStarted(phase TestPhase)
+	PhaseFinished(phase TestPhase)
+	// CallStarted is called right before a call is sent.
+	CallStarted(phase TestPhase)
+	// Collect receives the same results as the Collector.
+	Collect(result CallResult)
+}
+
+// callObserver is implemented by collectors that want to know when a call is sent.
+type callObserver interface {
+	CallStarted(phase TestPhase)
+}
+
+// resultMarker is implemented by observers that annotate results, such as the
+// cold start flag, before the Collector writes them and the other observers see
+// them.
+type resultMarker interface {
+	Mark(result *CallResult)
+}
+
+// runFailer is implemented by observers that report whether the run is complete,
+// told when part of the load went missing, such as a worker that failed.
+type runFailer interface {
+	RunFailed(failures []string)
+}
+
+// callCounters are counters by key, such as a phase name, that the call
+// goroutines update without a lock.
+type callCounters struct {
+	m sync.Map // key -> *atomic.Int64
+}
+
+func (c *callCounters) get(key string) *atomic.Int64 {
+	if n, ok := c.m.Load(key); ok {
+		return n.(*atomic.Int64)
+	}
+	n, _ := c.m.LoadOrStore(key, new(atomic.Int64))
+	return n.(*atomic.Int64)
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// parquetRowGroupSize is the number of rows per row group. Flushing smaller row
+// groups every second would make the file slow to scan.
+const parquetRowGroupSize = 100_000
+
+// parquetSink writes zstd compressed resultRecord rows.
+type parquetSink struct {
+	file   *os.File
+	writer *parquet.GenericWriter[resultRecord]
+	rows   []resultRecord
+}
+
+func newParquetSink(fileName string) (*parquetSink, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	writer := parquet.NewGenericWriter[resultRecord](file,
+		parquet.Compression(&zstd.Codec{}),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+	)
+	return &parquetSink{file: file, writer: writer, rows: make([]resultRecord, 0, 1)}, nil
+}
+
+func (s *parquetSink) Write(result CallResult) error {
+	s.rows = append(s.rows[:0], newResultRecord(result))
+	_, err := s.writer.Write(s.rows)
+	return err
+}
+
+// Flush does nothing; rows become durable once a row group is full or on Close.
+func (s *parquetSink) Flush() error {
+	return nil
+}
+
+func (s *parquetSink) Close() error {
+	closeErr := s.writer.Close()
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCPUPeriod is the period of CPU limits given in cores, 100ms in microseconds.
+const defaultCPUPeriod = 100000
+
+// CPU limits a function to Quota microseconds of CPU time every Period
+// microseconds. In the config it is either a map of period and quota or a number
+// of cores like 0.5 or 500m, which becomes a quota of a 100ms period.
+type CPU struct {
+	Period int64 `yaml:"period,omitempty" json:"period"`
+	Quota  int64 `yaml:"quota,omitempty" json:"quota"`
+}
+
+func (c *CPU) UnmarshalYAML(unmarshal func(any) error) error {
+	var quantity string
+	if err := unmarshal(&quantity); err == nil {
+		cores, err := parseCores(quantity)
+		if err != nil {
+			return err
+		}
+		c.Period = defaultCPUPeriod
+		c.Quota = int64(math.Round(cores * defaultCPUPeriod))
+		return nil
+	}
+	type plain CPU
+	return unmarshal((*plain)(c))
+}
+
+// String shows the limit in cores, e.g. 500m.
+func (c CPU) String() string {
+	if c.Period == 0 {
+		return "0m"
+	}
+	return fmt.Sprintf("%dm", int64(math.Round(float64(c.Quota)/float64(c.Period)*1000)))
+}
+
+// parseCores reads a number of cores, 0.5 or 2, or millicores like 500m.
+func parseCores(quantity string) (float64, error) {
+	numStr, divisor := strings.TrimSpace(quantity), 1.0
+	if n, ok := strings.CutSuffix(numStr, "m"); ok {
+		numStr, divisor = n, 1000
+	}
+	cores, err := strconv.ParseFloat(numStr, 64)
+	if err != nil || math.IsInf(cores, 0) || math.IsNaN(cores) || cores <= 0 {
+		return 0, fmt.Errorf("invalid cpu %q, expected cores like 0.5 or millicores like 500m", quantity)
+	}
+	return cores / divisor, nil
+}
+
+// ResourceSweep runs a phase once for every combination of the listed
+// resources, one after another, to compare the function at different sizes.
+type ResourceSweep struct {
+	Memory []string      `yaml:"memory,omitempty"`
+	Cpu    []CPU         `yaml:"cpu,omitempty"`
+	Pause  time.Duration `yaml:"pause,omitempty"` // between two runs of the phase, e.g. to let the instances of the last size idle out
+}
+
+// steps returns the function config of every run of the sweep, memory varying slowest.
+func (s *ResourceSweep) steps() []FunctionConfig {
+	memory := s.Memory
+	if len(memory) == 0 {
+		memory = []string{""}
+	}
+	cpus := make([]*CPU, 0, len(s.Cpu))
+	for i := range s.Cpu {
+		cpus = append(cpus, &s.Cpu[i])
+	}
+	if len(cpus) == 0 {
+		cpus = []*CPU{nil}
+	}
+
+	steps := make([]FunctionConfig, 0, len(memory)*len(cpus))
+	for _, m := range memory {
+		for _, cpu := range cpus {
+			steps = append(steps, FunctionConfig{Memory: m, Cpu: cpu})
+		}
+	}
+	return steps
+}
+
+// expandSweeps replaces every phase with a sweep by one phase per step of the
+// sweep. The steps start one after another from the phase's start time and are
+// named after the phase and their resources, e.g. steady/256Mi/500m.
+func expandSweeps(phases []TestPhase) []TestPhase {
+	expanded := make([]TestPhase, 0, len(phases))
+	for _, phase := range phases {
+		if phase.Sweep == nil {
+			expanded = append(expanded, phase)
+			continue
+		}
+		for i, step := range phase.Sweep.steps() {
+			p := phase
+			p.Sweep = nil
+			p.StartTime = phase.StartTime + time.Duration(i)*(phase.Duration+phase.Sweep.Pause)
+			name := []string{phase.Name}
+			if step.Memory != "" {
+				p.Memory = step.Memory
+				name = append(name, step.Memory)
+			}
+			if step.Cpu != nil {
+				p.Cpu = step.Cpu
+				name = append(name, step.Cpu.String())
+			}
+			p.Name = strings.Join(name, "/")
+			expanded = append(expanded, p)
+		}
+	}
+	return expanded
+}
+
+// resources returns the phase's own memory and CPU, nil if it uses those of its image tag.
+func (p TestPhase) resources() *FunctionConfig {
+	if p.Memory == "" && p.Cpu == nil {
+		return nil
+	}
+	return &FunctionConfig{Memory: p.Memory, Cpu: p.Cpu}
+}
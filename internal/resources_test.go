@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials/insecure"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConvertMemory(t *testing.T) {
+	tests := []struct {
+		memory  string
+		want    int64
+		wantErr bool
+	}{
+		{"256MB", 256 << 20, false},
+		{"1gb", 1 << 30, false},
+		{"512Mi", 512 << 20, false},
+		{"1.5Gi", 3 << 29, false},
+		{"100M", 100e6, false},
+		{"8388608", 8 << 20, false},
+		{" 64Mi ", 64 << 20, false},
+		{"4Mi", 0, true},
+		{"500m", 0, true},
+		{"lots", 0, true},
+		{"-1Gi", 0, true},
+		{"NaNGi", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := convertMemory(tt.memory)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("Expected %d (error %v) for %q, got %d (%v)", tt.want, tt.wantErr, tt.memory, got, err)
+		}
+	}
+}
+
+func TestCPU_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		want    CPU
+		wantErr bool
+	}{
+		{"cpu: 500m", CPU{Period: 100000, Quota: 50000}, false},
+		{"cpu: 0.25", CPU{Period: 100000, Quota: 25000}, false},
+		{"cpu: 2", CPU{Period: 100000, Quota: 200000}, false},
+		{`cpu: "1.5"`, CPU{Period: 100000, Quota: 150000}, false},
+		{"cpu: {period: 50000, quota: 10000}", CPU{Period: 50000, Quota: 10000}, false},
+		{"cpu: -1", CPU{}, true},
+		{"cpu: half", CPU{}, true},
+	}
+	for _, tt := range tests {
+		var fc FunctionConfig
+		err := yaml.Unmarshal([]byte(tt.yaml), &fc)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Expected error %v for %q, got %v", tt.wantErr, tt.yaml, err)
+			continue
+		}
+		if !tt.wantErr && *fc.Cpu != tt.want {
+			t.Errorf("Expected %+v for %q, got %+v", tt.want, tt.yaml, *fc.Cpu)
+		}
+	}
+}
+
+func TestExpandSweeps(t *testing.T) {
+	var phases []TestPhase
+	err := yaml.Unmarshal([]byte(`
+- name: steady
+  type: constant
+  start_time: 5s
+  duration: 10s
+  start_rps: 10
+  image_tag: hyperfaas-echo:latest
+  sweep:
+    memory: [128Mi, 256Mi]
+    cpu: [500m, 1]
+    pause: 2s
+- name: other
+  type: constant
+  duration: 10s
+  start_rps: 10
+  image_tag: hyperfaas-echo:latest
+`), &phases)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expanded := expandSweeps(phases)
+	want := []struct {
+		name  string
+		start time.Duration
+	}{
+		{"steady/128Mi/500m", 5 * time.Second},
+		{"steady/128Mi/1000m", 17 * time.Second},
+		{"steady/256Mi/500m", 29 * time.Second},
+		{"steady/256Mi/1000m", 41 * time.Second},
+		{"other", 0},
+	}
+	if len(expanded) != len(want) {
+		t.Fatalf("Expected %d phases, got %d", len(want), len(expanded))
+	}
+	for i, w := range want {
+		if p := expanded[i]; p.Name != w.name || p.StartTime != w.start || p.Sweep != nil {
+			t.Errorf("Expected %s at %v, got %s at %v", w.name, w.start, p.Name, p.StartTime)
+		}
+	}
+	if p := expanded[1]; p.Memory != "128Mi" || *p.Cpu != (CPU{Period: 100000, Quota: 100000}) {
+		t.Errorf("Expected 128Mi and a whole CPU, got %s and %+v", p.Memory, *p.Cpu)
+	}
+}
+
+func TestController_CreateFunctionsPerSize(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	address, err := NewFakeLeaf(nil, logger).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{
+		funcMgr: NewFunctionManager(address, nil, insecure.NewCredentials()),
+		Config: &Config{
+			FunctionConfig: map[string]*FunctionConfig{
+				"hyperfaas-echo:latest": resolveFunctionConfig("hyperfaas-echo:latest", nil, &FunctionConfig{Memory: "512Mi", FunctionIDs: []string{"existing"}}),
+			},
+			Workload: &Workload{Phases: expandSweeps([]TestPhase{
+				{Name: "default", ImageTag: "hyperfaas-echo:latest"},
+				{Name: "sweep", ImageTag: "hyperfaas-echo:latest", Sweep: &ResourceSweep{Memory: []string{"128Mi", "256Mi"}}},
+				{Name: "again", ImageTag: "hyperfaas-echo:latest", Memory: "268435456"},
+				{Name: "tag-size", ImageTag: "hyperfaas-echo:latest", Memory: "512MB"},
+			})},
+		},
+	}
+	c.CreateFunctions()
+
+	phases := c.Config.Workload.Phases
+	small, large, again := phases[1].FunctionID, phases[2].FunctionID, phases[3].FunctionID
+	if phases[0].FunctionID != "existing" {
+		t.Errorf("Expected the default phase on the configured function, got %s", phases[0].FunctionID)
+	}
+	if small == "existing" || small == large || large != again {
+		t.Errorf("Expected a new function per size shared by phases of the same size, got %s, %s and %s", small, large, again)
+	}
+	if phases[4].FunctionID != "existing" {
+		t.Errorf("Expected the phase of the tag's size on the configured function, got %s", phases[4].FunctionID)
+	}
+
+	sizes := make(map[string]int64)
+	for _, f := range c.funcMgr.Functions() {
+		sizes[f.ID] = f.Memory
+	}
+	if sizes[small] != 128<<20 || sizes[large] != 256<<20 {
+		t.Errorf("Expected functions of 128Mi and 256Mi, got %v", sizes)
+	}
+}
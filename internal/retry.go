@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultBackoffFactor  = 2
+)
+
+// defaultRetryableCodes are retried when a policy lists no codes.
+var defaultRetryableCodes = []string{"Unavailable"}
+
+// RetryPolicy resends calls that failed with a retryable status, waiting an
+// exponentially growing backoff between the attempts.
+type RetryPolicy struct {
+	MaxAttempts    int           `yaml:"max_attempts"`              // including the first call
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"` // 100ms if unset
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`     // 10s if unset
+	Multiplier     float64       `yaml:"multiplier,omitempty"`      // 2 if unset
+	RetryableCodes []string      `yaml:"retryable_codes,omitempty"` // gRPC status names like Unavailable or DEADLINE_EXCEEDED, Unavailable if unset
+
+	// retryable holds the parsed RetryableCodes, set by validate
+	retryable map[codes.Code]bool
+}
+
+func (p *RetryPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("retry: max_attempts must be at least 1")
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("retry: backoffs must not be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return fmt.Errorf("retry: multiplier must be at least 1")
+	}
+	names := p.RetryableCodes
+	if len(names) == 0 {
+		names = defaultRetryableCodes
+	}
+	retryable := make(map[codes.Code]bool, len(names))
+	for _, name := range names {
+		c, ok := parseCode(name)
+		if !ok {
+			return fmt.Errorf("retry: unknown gRPC status %q", name)
+		}
+		retryable[c] = true
+	}
+	p.retryable = retryable
+	return nil
+}
+
+// shouldRetry reports whether a call that failed with code on the given attempt
+// (counted from 1) is sent again. The policy must have been validated.
+func (p *RetryPolicy) shouldRetry(code codes.Code, attempt int) bool {
+	if p == nil || code == codes.OK || attempt >= p.MaxAttempts {
+		return false
+	}
+	return p.retryable[code]
+}
+
+// backoff is the wait after the given attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial := cmp.Or(p.InitialBackoff, defaultInitialBackoff)
+	maxBackoff := cmp.Or(p.MaxBackoff, defaultMaxBackoff)
+	multiplier := cmp.Or(p.Multiplier, defaultBackoffFactor)
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	return time.Duration(min(d, float64(maxBackoff)))
+}
+
+// parseCode accepts the Go name of a status code (DeadlineExceeded) as well as
+// the name used by the gRPC spec (DEADLINE_EXCEEDED).
+func parseCode(name string) (codes.Code, bool) {
+	name = strings.ReplaceAll(name, "_", "")
+	if strings.EqualFold(name, "Cancelled") {
+		return codes.Canceled, true
+	}
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.EqualFold(c.String(), name) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// sleepCtx waits for d and reports false if ctx ended first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
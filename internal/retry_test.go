@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/3s-rg-codes/HyperFaaS/proto/leaf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseCode(t *testing.T) {
+	tests := []struct {
+		name string
+		want codes.Code
+		ok   bool
+	}{
+		{"Unavailable", codes.Unavailable, true},
+		{"DEADLINE_EXCEEDED", codes.DeadlineExceeded, true},
+		{"resourceexhausted", codes.ResourceExhausted, true},
+		{"CANCELLED", codes.Canceled, true},
+		{"Broken", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseCode(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Expected %v %v for %s, got %v %v", tt.want, tt.ok, tt.name, got, ok)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond, RetryableCodes: []string{"UNAVAILABLE", "DeadlineExceeded"}}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.shouldRetry(codes.Unavailable, 1) || !p.shouldRetry(codes.DeadlineExceeded, 2) {
+		t.Errorf("Expected retryable codes to be retried before the last attempt")
+	}
+	if p.shouldRetry(codes.Unavailable, 3) || p.shouldRetry(codes.Internal, 1) || p.shouldRetry(codes.OK, 1) {
+		t.Errorf("Expected no retry on the last attempt, for other codes or on success")
+	}
+	if (*RetryPolicy)(nil).shouldRetry(codes.Unavailable, 1) {
+		t.Errorf("Expected no retries without a policy")
+	}
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 25 * time.Millisecond} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("Expected a backoff of %v after attempt %d, got %v", want, attempt, got)
+		}
+	}
+	defaults := &RetryPolicy{MaxAttempts: 2}
+	if err := defaults.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !defaults.shouldRetry(codes.Unavailable, 1) || defaults.shouldRetry(codes.DeadlineExceeded, 1) {
+		t.Errorf("Expected only Unavailable to be retried by default")
+	}
+	if err := (&RetryPolicy{MaxAttempts: 2, RetryableCodes: []string{"Sometimes"}}).validate(); err == nil {
+		t.Errorf("Expected an error for an unknown status")
+	}
+}
+
+// flakyClient fails with Unavailable until the given number of calls, and
+// otherwise waits for the deadline if hang is set.
+type flakyClient struct {
+	failures int64
+	hang     bool
+	calls    atomic.Int64
+}
+
+func (c *flakyClient) ScheduleCall(ctx context.Context, req *leaf.ScheduleCallRequest) (CallResult, error) {
+	start := time.Now()
+	if c.calls.Add(1) <= c.failures {
+		err := status.Error(codes.Unavailable, "leaf restarting")
+		return CallResult{Timestamp: start, Status: codes.Unavailable, Error: err.Error()}, err
+	}
+	if c.hang {
+		<-ctx.Done()
+		err := status.FromContextError(ctx.Err()).Err()
+		return CallResult{Timestamp: start, Latency: time.Since(start), Status: status.Code(err), Error: err.Error()}, err
+	}
+	return CallResult{Timestamp: start, Status: codes.OK}, nil
+}
+
+func TestSendCall_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		client       *flakyClient
+		phase        TestPhase
+		wantStatus   codes.Code
+		wantAttempts int
+	}{
+		{
+			name:         "succeeds on the third attempt",
+			client:       &flakyClient{failures: 2},
+			phase:        TestPhase{Retry: &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}},
+			wantStatus:   codes.OK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max attempts",
+			client:       &flakyClient{failures: 10},
+			phase:        TestPhase{Retry: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}},
+			wantStatus:   codes.Unavailable,
+			wantAttempts: 2,
+		},
+		{
+			name:         "no retries without a policy",
+			client:       &flakyClient{failures: 1},
+			wantStatus:   codes.Unavailable,
+			wantAttempts: 1,
+		},
+		{
+			name:         "call timeout",
+			client:       &flakyClient{hang: true},
+			phase:        TestPhase{CallTimeout: 10 * time.Millisecond},
+			wantStatus:   codes.DeadlineExceeded,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.phase.Retry.validate(); err != nil {
+				t.Fatal(err)
+			}
+			collector := &memoryCollector{}
+			sendCall(context.Background(), tt.client, collector, NewEchoDataProvider(8, 16), tt.phase, time.Now(), false)
+			if len(collector.results) != 1 {
+				t.Fatalf("Expected 1 result, got %d", len(collector.results))
+			}
+			result := collector.results[0]
+			if result.Status != tt.wantStatus || result.Attempts != tt.wantAttempts {
+				t.Errorf("Expected %v after %d attempts, got %v after %d", tt.wantStatus, tt.wantAttempts, result.Status, result.Attempts)
+			}
+			if tt.wantAttempts > 1 && !result.FirstSentTimestamp.Before(result.Timestamp) {
+				t.Errorf("Expected the first attempt to be sent before the last, got %v and %v", result.FirstSentTimestamp, result.Timestamp)
+			}
+			if got := tt.client.calls.Load(); got != int64(tt.wantAttempts) {
+				t.Errorf("Expected %d calls, got %d", tt.wantAttempts, got)
+			}
+		})
+	}
+}
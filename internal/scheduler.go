@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	ArrivalUniform     = "uniform"
+	ArrivalExponential = "exponential"
+)
+
+// ArrivalScheduler paces open-loop requests within each second according to an
+// inter-arrival distribution. The schedule is absolute (relative to start), so a
+// late wakeup is caught up on the next arrival instead of shifting every later one.
+type ArrivalScheduler struct {
+	start        time.Time
+	distribution string
+	random       *rand.Rand
+}
+
+func NewArrivalScheduler(start time.Time, distribution string, seed uint64) *ArrivalScheduler {
+	if distribution == "" {
+		distribution = ArrivalUniform
+	}
+	return &ArrivalScheduler{
+		start:        start,
+		distribution: distribution,
+		random:       rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// Run schedules arrivals until ctx is done. rate is asked for the target RPS of
+// each second of the schedule (0 is the first second); fire is called with the
+// intended send time of each arrival.
+func (s *ArrivalScheduler) Run(ctx context.Context, rate func(second int) int, fire func(intended time.Time)) {
+	deadline, hasDeadline := ctx.Deadline()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	next := s.start
+	current, sent := -1, 0
+	for {
+		if ctx.Err() != nil || hasDeadline && !next.Before(deadline) {
+			return
+		}
+
+		second := int(next.Sub(s.start) / time.Second)
+		if second != current {
+			current, sent = second, 0
+		}
+		rps := rate(second)
+		if rps <= 0 || s.distribution == ArrivalUniform && sent >= rps {
+			// nothing (more) to send this second, jump to the start of the next one
+			next = s.start.Add(time.Duration(second+1) * time.Second)
+			continue
+		}
+
+		if wait := time.Until(next); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		fire(next)
+		sent++
+
+		switch s.distribution {
+		case ArrivalExponential:
+			next = next.Add(time.Duration(s.random.ExpFloat64() / float64(rps) * float64(time.Second)))
+		default:
+			// derive each slot from the second boundary so rounding never accumulates
+			next = s.start.Add(time.Duration(second)*time.Second + time.Duration(sent)*time.Second/time.Duration(rps))
+		}
+	}
+}
+
+func validateArrival(distribution string) error {
+	switch distribution {
+	case "", ArrivalUniform, ArrivalExponential:
+		return nil
+	default:
+		return fmt.Errorf("unsupported arrival distribution: %s (expected %s or %s)", distribution, ArrivalUniform, ArrivalExponential)
+	}
+}
+
+// RunPiecewise schedules arrivals for a rate that may change at arbitrary points
+// in time rather than on second boundaries. rate returns the RPS at the given
+// offset from start and the offset until which that rate holds.
+func (s *ArrivalScheduler) RunPiecewise(ctx context.Context, rate func(elapsed time.Duration) (rps float64, until time.Duration), fire func(intended time.Time)) {
+	deadline, hasDeadline := ctx.Deadline()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	at := s.start
+	for {
+		if ctx.Err() != nil || hasDeadline && !at.Before(deadline) {
+			return
+		}
+
+		rps, until := rate(at.Sub(s.start))
+		end := s.start.Add(until)
+		if !end.After(at) {
+			return
+		}
+		if rps <= 0 {
+			at = end
+			continue
+		}
+
+		var gap time.Duration
+		arrival := at
+		switch s.distribution {
+		case ArrivalExponential:
+			gap = time.Duration(s.random.ExpFloat64() / rps * float64(time.Second))
+			arrival = at.Add(gap)
+		default:
+			gap = time.Duration(float64(time.Second) / rps)
+		}
+		if !arrival.Before(end) {
+			// the rate changes before the next arrival; continue from the boundary.
+			// For exponential arrivals this is exact because they are memoryless.
+			at = end
+			continue
+		}
+		if hasDeadline && !arrival.Before(deadline) {
+			return
+		}
+
+		if wait := time.Until(arrival); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		fire(arrival)
+		if s.distribution == ArrivalExponential {
+			at = arrival
+		} else {
+			at = arrival.Add(gap)
+		}
+	}
+}
+
+// RunAt fires once for every offset from start. offsets must be sorted ascending.
+func (s *ArrivalScheduler) RunAt(ctx context.Context, offsets []time.Duration, fire func(intended time.Time)) {
+	deadline, hasDeadline := ctx.Deadline()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for _, offset := range offsets {
+		arrival := s.start.Add(offset)
+		if ctx.Err() != nil || hasDeadline && !arrival.Before(deadline) {
+			return
+		}
+
+		if wait := time.Until(arrival); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+
+		fire(arrival)
+	}
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestArrivalScheduler_UniformSpreadsWithinSecond(t *testing.T) {
+	start := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(200*time.Millisecond))
+	defer cancel()
+
+	var intended []time.Time
+	NewArrivalScheduler(start, ArrivalUniform, 1).Run(ctx, func(int) int { return 50 }, func(at time.Time) {
+		intended = append(intended, at)
+	})
+
+	if len(intended) != 10 {
+		t.Fatalf("Expected 10 arrivals in 200ms at 50 RPS, got %d", len(intended))
+	}
+	for i, at := range intended {
+		expected := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if !at.Equal(expected) {
+			t.Errorf("Arrival %d: expected intended time %v, got %v", i, expected.Sub(start), at.Sub(start))
+		}
+	}
+}
+
+func TestArrivalScheduler_SkipsSecondsWithoutLoad(t *testing.T) {
+	// start in the past so the whole schedule is overdue and fires immediately
+	start := time.Now().Add(-3*time.Second + 100*time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(3*time.Second))
+	defer cancel()
+
+	perSecond := make(map[int]int)
+	NewArrivalScheduler(start, ArrivalUniform, 1).Run(ctx, func(second int) int {
+		if second == 1 {
+			return 0
+		}
+		return 3
+	}, func(at time.Time) {
+		perSecond[int(at.Sub(start)/time.Second)]++
+	})
+
+	expected := map[int]int{0: 3, 2: 3}
+	for second, count := range expected {
+		if perSecond[second] != count {
+			t.Errorf("Second %d: expected %d arrivals, got %d", second, count, perSecond[second])
+		}
+	}
+	if perSecond[1] != 0 {
+		t.Errorf("Second 1: expected no arrivals, got %d", perSecond[1])
+	}
+}
+
+func TestRampValue(t *testing.T) {
+	tests := []struct {
+		start, end, step, second, expected int
+	}{
+		{10, 50, 10, 0, 10},
+		{10, 50, 10, 2, 30},
+		{10, 50, 15, 3, 50},
+		{50, 10, -20, 1, 30},
+		{50, 10, -20, 5, 10},
+	}
+	for _, tt := range tests {
+		if got := rampValue(tt.start, tt.end, tt.step, tt.second); got != tt.expected {
+			t.Errorf("rampValue(%d, %d, %d, %d) = %d, expected %d", tt.start, tt.end, tt.step, tt.second, got, tt.expected)
+		}
+	}
+}
+
+func TestArrivalScheduler_PiecewiseRateChanges(t *testing.T) {
+	// overdue schedule: the first 2s fire immediately, then the deadline ends it
+	start := time.Now().Add(-2*time.Second + 100*time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(2*time.Second))
+	defer cancel()
+
+	var intended []time.Duration
+	NewArrivalScheduler(start, ArrivalUniform, 1).RunPiecewise(ctx, func(elapsed time.Duration) (float64, time.Duration) {
+		if elapsed < 1500*time.Millisecond {
+			return 2, 1500 * time.Millisecond
+		}
+		return 10, 2 * time.Second
+	}, func(at time.Time) {
+		intended = append(intended, at.Sub(start))
+	})
+
+	expected := []time.Duration{0, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 1600 * time.Millisecond, 1700 * time.Millisecond, 1800 * time.Millisecond, 1900 * time.Millisecond}
+	if len(intended) != len(expected) {
+		t.Fatalf("Expected %d arrivals, got %d: %v", len(expected), len(intended), intended)
+	}
+	for i := range expected {
+		if intended[i] != expected[i] {
+			t.Errorf("Arrival %d: expected %v, got %v", i, expected[i], intended[i])
+		}
+	}
+}
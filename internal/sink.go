@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+	FormatSQLite  = "sqlite"
+)
+
+// Sink stores the call results of a run. The Collector serializes calls to a
+// sink, so implementations need not be safe for concurrent use.
+type Sink interface {
+	Write(result CallResult) error
+	// Flush makes the rows written so far durable where the format allows it.
+	Flush() error
+	Close() error
+}
+
+// NewSink creates the sink for fileName. An empty format is derived from the
+// file extension, falling back to CSV.
+func NewSink(fileName string, format string) (Sink, error) {
+	if format == "" {
+		format = formatFromExtension(fileName)
+	}
+	switch format {
+	case FormatCSV:
+		return newCSVSink(fileName)
+	case FormatJSONL:
+		return newJSONLSink(fileName)
+	case FormatParquet:
+		return newParquetSink(fileName)
+	case FormatSQLite:
+		return newSQLiteSink(fileName)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s (expected %s, %s, %s or %s)", format, FormatCSV, FormatJSONL, FormatParquet, FormatSQLite)
+	}
+}
+
+func formatFromExtension(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".parquet":
+		return FormatParquet
+	case ".sqlite", ".sqlite3", ".db":
+		return FormatSQLite
+	default:
+		return FormatCSV
+	}
+}
+
+// resultRecord is the typed row written by the JSONL, Parquet and SQLite sinks.
+// Timestamps are nanoseconds since the epoch, durations are nanoseconds.
+type resultRecord struct {
+	Timestamp                  int64      `json:"timestamp_ns" parquet:"timestamp_ns,timestamp(nanosecond)"`
+	IntendedTimestamp          epochNanos `json:"intended_timestamp_ns" parquet:"intended_timestamp_ns,optional,timestamp(nanosecond)"` // null for rows that were not scheduled
+	Phase                      string     `json:"phase" parquet:"phase,dict"`
+	FunctionID                 string     `json:"function_id" parquet:"function_id,dict"`
+	ImageTag                   string     `json:"image_tag" parquet:"image_tag,dict"`
+	Target                     string     `json:"target,omitempty" parquet:"target,dict"`
+	Connection                 int32      `json:"connection" parquet:"connection"`
+	Attempts                   int32      `json:"attempts" parquet:"attempts"`
+	Latency                    int64      `json:"latency_ns" parquet:"latency_ns"`
+	SendDelay                  int64      `json:"send_delay_ns" parquet:"send_delay_ns"`
+	Status                     string     `json:"status" parquet:"status,dict"`
+	StatusCode                 int32      `json:"status_code" parquet:"status_code"`
+	Error                      string     `json:"error,omitempty" parquet:"error"`
+	Outcome                    string     `json:"outcome" parquet:"outcome,dict"`
+	RequestSize                int64      `json:"request_size_bytes" parquet:"request_size_bytes"`
+	ResponseSize               int64      `json:"response_size_bytes" parquet:"response_size_bytes"`
+	InstanceID                 string     `json:"instance_id,omitempty" parquet:"instance_id,dict"`
+	ColdStart                  bool       `json:"cold_start" parquet:"cold_start"`
+	LeafGotRequestTimestamp    int64      `json:"leaf_got_request_timestamp_ns" parquet:"leaf_got_request_timestamp_ns,timestamp(nanosecond)"`
+	LeafScheduledCallTimestamp int64      `json:"leaf_scheduled_call_timestamp_ns" parquet:"leaf_scheduled_call_timestamp_ns,timestamp(nanosecond)"`
+	CallQueuedTimestamp        int64      `json:"call_queued_timestamp_ns" parquet:"call_queued_timestamp_ns,timestamp(nanosecond)"`
+	GotResponseTimestamp       int64      `json:"got_response_timestamp_ns" parquet:"got_response_timestamp_ns,timestamp(nanosecond)"`
+	FunctionProcessingTime     int64      `json:"function_processing_time_ns" parquet:"function_processing_time_ns"`
+	// latency breakdown, null if a trailer was missing
+	NetworkToLeaf     *int64 `json:"network_to_leaf_ns" parquet:"network_to_leaf_ns,optional"`
+	LeafScheduling    *int64 `json:"leaf_scheduling_ns" parquet:"leaf_scheduling_ns,optional"`
+	Queueing          *int64 `json:"queueing_ns" parquet:"queueing_ns,optional"`
+	FunctionExecution *int64 `json:"function_execution_ns" parquet:"function_execution_ns,optional"`
+	ResponsePath      *int64 `json:"response_path_ns" parquet:"response_path_ns,optional"`
+}
+
+func newResultRecord(result CallResult) resultRecord {
+	r := resultRecord{
+		Timestamp:                  unixNano(result.Timestamp),
+		IntendedTimestamp:          epochNanos(unixNano(result.IntendedTimestamp)),
+		Phase:                      result.Phase,
+		FunctionID:                 result.FunctionID,
+		ImageTag:                   result.ImageTag,
+		Target:                     result.Target,
+		Connection:                 int32(result.Connection),
+		Attempts:                   int32(result.Attempts),
+		Latency:                    result.Latency.Nanoseconds(),
+		SendDelay:                  result.SendDelay().Nanoseconds(),
+		Status:                     result.Status.String(),
+		StatusCode:                 int32(result.Status),
+		Error:                      result.Error,
+		Outcome:                    result.Outcome,
+		RequestSize:                result.RequestSize,
+		ResponseSize:               result.ResponseSize,
+		InstanceID:                 result.InstanceID,
+		ColdStart:                  result.ColdStart,
+		LeafGotRequestTimestamp:    unixNano(result.LeafGotRequestTimestamp),
+		LeafScheduledCallTimestamp: unixNano(result.LeafScheduledCallTimestamp),
+		CallQueuedTimestamp:        unixNano(result.CallQueuedTimestamp),
+		GotResponseTimestamp:       unixNano(result.GotResponseTimestamp),
+		FunctionProcessingTime:     result.FunctionProcessingTime.Nanoseconds(),
+	}
+	if b, ok := result.Breakdown(); ok {
+		r.NetworkToLeaf = durationPtr(b.NetworkToLeaf)
+		r.LeafScheduling = durationPtr(b.LeafScheduling)
+		r.Queueing = durationPtr(b.Queueing)
+		r.FunctionExecution = durationPtr(b.FunctionExecution)
+		r.ResponsePath = durationPtr(b.ResponsePath)
+	}
+	return r
+}
+
+func durationPtr(d time.Duration) *int64 {
+	ns := d.Nanoseconds()
+	return &ns
+}
+
+// unixNano keeps unset timestamps at 0 instead of the large negative value of the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// epochNanos is an optional timestamp in nanoseconds since the epoch. Zero is
+// unset and written as null, by Parquet through its optional tag.
+type epochNanos int64
+
+func (n epochNanos) MarshalJSON() ([]byte, error) {
+	if n == 0 {
+		return []byte("null"), nil
+	}
+	return strconv.AppendInt(nil, int64(n), 10), nil
+}
+
+func (n epochNanos) Value() (driver.Value, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	return int64(n), nil
+}
+
+// csvSink writes the CSV_HEADERS columns, with RFC3339 timestamps at nanosecond
+// precision and durations in nanoseconds.
+type csvSink struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newCSVSink(fileName string) (*csvSink, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write(CSV_HEADERS); err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer.Flush()
+	return &csvSink{file: file, writer: writer}, nil
+}
+
+func (s *csvSink) Write(result CallResult) error {
+	breakdown := make([]string, 5)
+	if b, ok := result.Breakdown(); ok {
+		for i, d := range []time.Duration{b.NetworkToLeaf, b.LeafScheduling, b.Queueing, b.FunctionExecution, b.ResponsePath} {
+			breakdown[i] = strconv.FormatInt(d.Nanoseconds(), 10)
+		}
+	}
+	return s.writer.Write(append([]string{
+		result.Timestamp.Format(time.RFC3339Nano),
+		result.FunctionID,
+		result.ImageTag,
+		strconv.FormatInt(result.Latency.Nanoseconds(), 10),
+		result.Status.String(),
+		result.Error,
+		strconv.FormatInt(result.RequestSize, 10),
+		strconv.FormatInt(result.ResponseSize, 10),
+		formatTrailerTime(result.CallQueuedTimestamp),
+		formatTrailerTime(result.GotResponseTimestamp),
+		result.InstanceID,
+		formatTrailerTime(result.LeafGotRequestTimestamp),
+		formatTrailerTime(result.LeafScheduledCallTimestamp),
+		formatTrailerDuration(result.FunctionProcessingTime),
+		formatTrailerTime(result.IntendedTimestamp),
+		strconv.FormatInt(result.SendDelay().Nanoseconds(), 10),
+		result.Outcome,
+		result.Phase,
+	}, append(breakdown, strconv.FormatBool(result.ColdStart), result.Target, strconv.Itoa(result.Connection), strconv.Itoa(result.Attempts))...))
+}
+
+// formatTrailerTime leaves missing trailers empty.
+func formatTrailerTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func formatTrailerDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return strconv.FormatInt(d.Nanoseconds(), 10)
+}
+
+func (s *csvSink) Flush() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvSink) Close() error {
+	flushErr := s.Flush()
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// jsonlSink writes one resultRecord per line.
+type jsonlSink struct {
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newJSONLSink(fileName string) (*jsonlSink, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(file)
+	return &jsonlSink{file: file, buf: buf, encoder: json.NewEncoder(buf)}, nil
+}
+
+func (s *jsonlSink) Write(result CallResult) error {
+	return s.encoder.Encode(newResultRecord(result))
+}
+
+func (s *jsonlSink) Flush() error {
+	return s.buf.Flush()
+}
+
+func (s *jsonlSink) Close() error {
+	flushErr := s.Flush()
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
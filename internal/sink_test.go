@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"google.golang.org/grpc/codes"
+)
+
+func TestFormatFromExtension(t *testing.T) {
+	tests := []struct {
+		fileName string
+		expected string
+	}{
+		{"results.csv", FormatCSV},
+		{"results.jsonl", FormatJSONL},
+		{"results.ndjson", FormatJSONL},
+		{"results.parquet", FormatParquet},
+		{"results.sqlite", FormatSQLite},
+		{"results.DB", FormatSQLite},
+		{"results", FormatCSV},
+	}
+	for _, tt := range tests {
+		if format := formatFromExtension(tt.fileName); format != tt.expected {
+			t.Errorf("Expected format %s for %s, got %s", tt.expected, tt.fileName, format)
+		}
+	}
+}
+
+func TestNewSink_UnknownFormat(t *testing.T) {
+	if _, err := NewSink(filepath.Join(t.TempDir(), "results.csv"), "xml"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+// sinkResults returns results with timestamps that only differ below a second.
+func sinkResults() []CallResult {
+	intended := time.Date(2025, 7, 1, 12, 0, 0, 123456789, time.UTC)
+	return []CallResult{
+		{Timestamp: intended.Add(42), IntendedTimestamp: intended, Phase: "steady", ImageTag: "echo:latest", FunctionID: "f1", Latency: 1500 * time.Microsecond, Outcome: OutcomeSent, RequestSize: 8, InstanceID: "i1",
+			LeafGotRequestTimestamp: intended.Add(200 * time.Microsecond), LeafScheduledCallTimestamp: intended.Add(300 * time.Microsecond),
+			CallQueuedTimestamp: intended.Add(400 * time.Microsecond), GotResponseTimestamp: intended.Add(1400 * time.Microsecond), FunctionProcessingTime: 900 * time.Microsecond},
+		{Timestamp: intended.Add(time.Millisecond), Phase: "steady", ImageTag: "echo:latest", Status: codes.ResourceExhausted, Error: "dropped by in-flight limiter", Connection: noConnection, Outcome: OutcomeDropped},
+	}
+}
+
+func writeSink(t *testing.T, fileName string) {
+	t.Helper()
+	sink, err := NewSink(fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range sinkResults() {
+		if err := sink.Write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRecords(t *testing.T, records []resultRecord) {
+	t.Helper()
+	results := sinkResults()
+	if len(records) != len(results) {
+		t.Fatalf("Expected %d rows, got %d", len(results), len(records))
+	}
+	if records[0].Timestamp != results[0].Timestamp.UnixNano() || records[0].IntendedTimestamp != epochNanos(results[0].IntendedTimestamp.UnixNano()) || records[0].SendDelay != 42 {
+		t.Errorf("Expected nanosecond timestamps, got %d with send delay %d", records[0].Timestamp, records[0].SendDelay)
+	}
+	if records[0].Latency != int64(1500*time.Microsecond) || records[0].InstanceID != "i1" {
+		t.Errorf("Expected the first row to round-trip, got %+v", records[0])
+	}
+	if records[0].Queueing == nil || *records[0].Queueing != int64(200*time.Microsecond) || records[0].FunctionProcessingTime != int64(900*time.Microsecond) {
+		t.Errorf("Expected the trailers and breakdown to round-trip, got %+v", records[0])
+	}
+	if records[1].NetworkToLeaf != nil || records[1].LeafGotRequestTimestamp != 0 {
+		t.Errorf("Expected no breakdown for the dropped row, got %+v", records[1])
+	}
+	if records[1].StatusCode != int32(codes.ResourceExhausted) || records[1].Outcome != OutcomeDropped || records[1].IntendedTimestamp != 0 || records[1].Connection != noConnection {
+		t.Errorf("Expected the dropped row to round-trip, got %+v", records[1])
+	}
+}
+
+func TestCSVSink(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "results.csv")
+	writeSink(t, fileName)
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || len(rows[0]) != len(CSV_HEADERS) {
+		t.Fatalf("Expected a header and 2 rows of %d columns, got %v", len(CSV_HEADERS), rows)
+	}
+	if rows[1][0] != "2025-07-01T12:00:00.123456831Z" {
+		t.Errorf("Expected a nanosecond timestamp, got %s", rows[1][0])
+	}
+	queueing := slices.Index(CSV_HEADERS, "queueing_ns")
+	if rows[1][queueing] != "200000" || rows[2][queueing] != "" {
+		t.Errorf("Expected a queueing time of 200000ns and none for the dropped row, got %q and %q", rows[1][queueing], rows[2][queueing])
+	}
+}
+
+func TestCSVSink_InterruptMarker(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "results.csv")
+	sink, err := NewSink(fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	if err := sink.Write(interruptMarker(at, 2470*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected a header and the marker row, got %v", rows)
+	}
+	want := map[string]string{
+		"timestamp":          "2025-07-01T12:00:00Z",
+		"status":             "Canceled",
+		"error":              "run interrupted after 2.47s",
+		"outcome":            OutcomeInterrupted,
+		"connection":         "-1",
+		"intended_timestamp": "",
+	}
+	for column, value := range want {
+		if got := rows[1][slices.Index(CSV_HEADERS, column)]; got != value {
+			t.Errorf("Expected %s %q for the marker, got %q", column, value, got)
+		}
+	}
+}
+
+func TestJSONLSink(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "results.jsonl")
+	writeSink(t, fileName)
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []resultRecord
+	var intended []any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r resultRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+		var raw map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			t.Fatal(err)
+		}
+		intended = append(intended, raw["intended_timestamp_ns"])
+	}
+	checkRecords(t, records)
+	if intended[1] != nil {
+		t.Errorf("Expected a null intended timestamp for the dropped row, got %v", intended[1])
+	}
+}
+
+func TestParquetSink(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "results.parquet")
+	writeSink(t, fileName)
+
+	records, err := parquet.ReadFile[resultRecord](fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, records)
+
+	type intendedRecord struct {
+		IntendedTimestamp *int64 `parquet:"intended_timestamp_ns,optional"`
+	}
+	intended, err := parquet.ReadFile[intendedRecord](fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intended[0].IntendedTimestamp == nil || intended[1].IntendedTimestamp != nil {
+		t.Errorf("Expected an intended timestamp for the first row and null for the dropped one, got %v and %v", intended[0].IntendedTimestamp, intended[1].IntendedTimestamp)
+	}
+}
+
+func TestSQLiteSink(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "results.sqlite")
+	writeSink(t, fileName)
+
+	db, err := sql.Open("sqlite", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT timestamp_ns, intended_timestamp_ns, latency_ns, send_delay_ns, status_code, outcome, connection, instance_id, leaf_got_request_timestamp_ns, function_processing_time_ns, network_to_leaf_ns, queueing_ns FROM results ORDER BY timestamp_ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var records []resultRecord
+	for rows.Next() {
+		var r resultRecord
+		var intended sql.NullInt64
+		if err := rows.Scan(&r.Timestamp, &intended, &r.Latency, &r.SendDelay, &r.StatusCode, &r.Outcome, &r.Connection, &r.InstanceID, &r.LeafGotRequestTimestamp, &r.FunctionProcessingTime, &r.NetworkToLeaf, &r.Queueing); err != nil {
+			t.Fatal(err)
+		}
+		if intended.Valid != (len(records) == 0) {
+			t.Errorf("Expected an intended timestamp only for the first row, got %v", intended)
+		}
+		r.IntendedTimestamp = epochNanos(intended.Int64)
+		records = append(records, r)
+	}
+	checkRecords(t, records)
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"database/sql"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBatchSize is the number of rows inserted per transaction.
+const sqliteBatchSize = 10_000
+
+const sqliteSchema = `CREATE TABLE results (
+	timestamp_ns INTEGER NOT NULL,
+	intended_timestamp_ns INTEGER,
+	phase TEXT NOT NULL,
+	function_id TEXT NOT NULL,
+	image_tag TEXT NOT NULL,
+	target TEXT NOT NULL,
+	connection INTEGER NOT NULL,
+	attempts INTEGER NOT NULL,
+	latency_ns INTEGER NOT NULL,
+	send_delay_ns INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	status_code INTEGER NOT NULL,
+	error TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	request_size_bytes INTEGER NOT NULL,
+	response_size_bytes INTEGER NOT NULL,
+	instance_id TEXT NOT NULL,
+	cold_start INTEGER NOT NULL,
+	leaf_got_request_timestamp_ns INTEGER NOT NULL,
+	leaf_scheduled_call_timestamp_ns INTEGER NOT NULL,
+	call_queued_timestamp_ns INTEGER NOT NULL,
+	got_response_timestamp_ns INTEGER NOT NULL,
+	function_processing_time_ns INTEGER NOT NULL,
+	network_to_leaf_ns INTEGER,
+	leaf_scheduling_ns INTEGER,
+	queueing_ns INTEGER,
+	function_execution_ns INTEGER,
+	response_path_ns INTEGER
+)`
+
+const sqliteInsert = `INSERT INTO results VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// sqliteSink inserts resultRecord rows into a results table, batched in transactions.
+type sqliteSink struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	insert  *sql.Stmt
+	pending int
+}
+
+func newSQLiteSink(fileName string) (*sqliteSink, error) {
+	// start from an empty database like the other sinks start from an empty file
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", fileName)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL", sqliteSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	s := &sqliteSink{db: db}
+	if err := s.begin(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqliteSink) begin() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(sqliteInsert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	s.tx = tx
+	s.insert = insert
+	s.pending = 0
+	return nil
+}
+
+func (s *sqliteSink) Write(result CallResult) error {
+	r := newResultRecord(result)
+	_, err := s.insert.Exec(
+		r.Timestamp, r.IntendedTimestamp, r.Phase, r.FunctionID, r.ImageTag, r.Target, r.Connection, r.Attempts,
+		r.Latency, r.SendDelay, r.Status, r.StatusCode, r.Error, r.Outcome,
+		r.RequestSize, r.ResponseSize, r.InstanceID, r.ColdStart,
+		r.LeafGotRequestTimestamp, r.LeafScheduledCallTimestamp, r.CallQueuedTimestamp, r.GotResponseTimestamp, r.FunctionProcessingTime,
+		r.NetworkToLeaf, r.LeafScheduling, r.Queueing, r.FunctionExecution, r.ResponsePath,
+	)
+	if err != nil {
+		return err
+	}
+	s.pending++
+	if s.pending >= sqliteBatchSize {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush commits the current transaction and starts the next one.
+func (s *sqliteSink) Flush() error {
+	if err := s.commit(); err != nil {
+		return err
+	}
+	return s.begin()
+}
+
+func (s *sqliteSink) commit() error {
+	s.insert.Close()
+	return s.tx.Commit()
+}
+
+func (s *sqliteSink) Close() error {
+	commitErr := s.commit()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return commitErr
+}
@@ -0,0 +1,362 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"google.golang.org/grpc/codes"
+)
+
+// Summary is a RunObserver that aggregates the whole run into an end-of-run
+// report per phase, per image tag and per Leaf.
+type Summary struct {
+	mu          sync.Mutex
+	phases      []TestPhase
+	start       time.Time
+	end         time.Time
+	phaseStart  map[string]time.Time
+	phaseEnd    map[string]time.Time
+	byPhase     map[string]*summaryStats
+	byTag       map[string]*summaryStats
+	byTarget    map[string]*summaryStats
+	interrupted bool
+	failures    []string
+}
+
+type summaryStats struct {
+	requests  int64
+	sent      int64
+	succeeded int64
+	dropped   int64
+	latencies *hdrhistogram.Histogram
+	errors    map[codes.Code]int64
+	// latencies of the calls flagged as cold starts
+	coldLatencies *hdrhistogram.Histogram
+}
+
+func NewSummary() *Summary {
+	return &Summary{
+		phaseStart: make(map[string]time.Time),
+		phaseEnd:   make(map[string]time.Time),
+		byPhase:    make(map[string]*summaryStats),
+		byTag:      make(map[string]*summaryStats),
+		byTarget:   make(map[string]*summaryStats),
+	}
+}
+
+func (s *Summary) RunStarted(phases []TestPhase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phases = phases
+	s.start = time.Now()
+}
+
+func (s *Summary) PhaseStarted(phase TestPhase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phaseStart[phase.Name] = time.Now()
+}
+
+func (s *Summary) PhaseFinished(phase TestPhase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.phaseEnd[phase.Name] = now
+	if now.After(s.end) {
+		s.end = now
+	}
+}
+
+func (s *Summary) CallStarted(phase TestPhase) {}
+
+// RunFailed marks the run as failed, its results miss part of the load.
+func (s *Summary) RunFailed(failures []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failures...)
+}
+
+func (s *Summary) Collect(result CallResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if result.Outcome == OutcomeInterrupted {
+		s.interrupted = true
+		return
+	}
+	groups := []*summaryStats{s.stats(s.byPhase, result.Phase), s.stats(s.byTag, result.ImageTag)}
+	// dropped calls never reached a Leaf
+	if result.Target != "" {
+		groups = append(groups, s.stats(s.byTarget, result.Target))
+	}
+	for _, stats := range groups {
+		stats.requests++
+		if result.Status != codes.OK {
+			stats.errors[result.Status]++
+		}
+		if result.Outcome == OutcomeDropped {
+			stats.dropped++
+			continue
+		}
+		stats.sent++
+		stats.latencies.RecordValue(clampHistogramValue(result.Latency))
+		if result.Status == codes.OK {
+			stats.succeeded++
+		}
+		if result.ColdStart {
+			stats.coldLatencies.RecordValue(clampHistogramValue(result.Latency))
+		}
+	}
+}
+
+func (s *Summary) stats(m map[string]*summaryStats, key string) *summaryStats {
+	stats, ok := m[key]
+	if !ok {
+		stats = &summaryStats{
+			latencies:     hdrhistogram.New(histogramMin, histogramMax, histogramDigits),
+			errors:        make(map[codes.Code]int64),
+			coldLatencies: hdrhistogram.New(histogramMin, histogramMax, histogramDigits),
+		}
+		m[key] = stats
+	}
+	return stats
+}
+
+// SummaryReport is the end-of-run report. Durations are in nanoseconds in JSON.
+type SummaryReport struct {
+	Duration    time.Duration `json:"duration_ns"`
+	Interrupted bool          `json:"interrupted"`
+	Failures    []string      `json:"failures,omitempty"` // why part of the load is missing, e.g. failed workers
+	Phases      []SummaryRow  `json:"phases"`
+	ImageTags   []SummaryRow  `json:"image_tags"`
+	Targets     []SummaryRow  `json:"targets,omitempty"` // per Leaf address
+}
+
+type SummaryRow struct {
+	Name        string           `json:"name"`
+	Type        string           `json:"type,omitempty"`
+	ImageTag    string           `json:"image_tag,omitempty"`
+	Requests    int64            `json:"requests"`
+	Sent        int64            `json:"sent"`
+	Succeeded   int64            `json:"succeeded"`
+	Dropped     int64            `json:"dropped"`
+	SuccessRate float64          `json:"success_rate"`
+	TargetRPS   *float64         `json:"target_rps,omitempty"` // nil for phases without a rate target
+	AchievedRPS float64          `json:"achieved_rps"`
+	Latency     LatencySummary   `json:"latency"`
+	Errors      map[string]int64 `json:"errors,omitempty"`
+	// ColdStarts is the number of calls that were the first to reach their instance
+	ColdStarts       int           `json:"cold_starts"`
+	ColdStartLatency time.Duration `json:"cold_start_latency_p50_ns"` // median latency of the cold starts
+}
+
+type LatencySummary struct {
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P95  time.Duration `json:"p95_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// Report builds the report from everything collected so far.
+func (s *Summary) Report() SummaryReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end := s.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	report := SummaryReport{
+		Duration:    end.Sub(s.start),
+		Interrupted: s.interrupted,
+		Failures:    slices.Clone(s.failures),
+	}
+
+	expectedByTag := make(map[string]float64)
+	untargetedTags := make(map[string]bool)
+	for _, phase := range s.phases {
+		row := s.stats(s.byPhase, phase.Name).row(phase.Name)
+		row.Type = phase.Type
+		row.ImageTag = phase.ImageTag
+
+		started, ok := s.phaseStart[phase.Name]
+		if !ok {
+			// never started, e.g. skipped after an interrupt
+			report.Phases = append(report.Phases, row)
+			continue
+		}
+		finished, ok := s.phaseEnd[phase.Name]
+		if !ok {
+			finished = end
+		}
+		if elapsed := finished.Sub(started).Seconds(); elapsed > 0 {
+			row.AchievedRPS = float64(row.Sent) / elapsed
+		}
+		if expected, ok := ExpectedRequests(phase); ok && phase.Duration > 0 {
+			target := expected / phase.Duration.Seconds()
+			row.TargetRPS = &target
+			expectedByTag[phase.ImageTag] += expected
+		} else {
+			untargetedTags[phase.ImageTag] = true
+		}
+		report.Phases = append(report.Phases, row)
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(s.byTag)) {
+		row := s.byTag[tag].row(tag)
+		if seconds := report.Duration.Seconds(); seconds > 0 {
+			row.AchievedRPS = float64(row.Sent) / seconds
+			if !untargetedTags[tag] {
+				target := expectedByTag[tag] / seconds
+				row.TargetRPS = &target
+			}
+		}
+		report.ImageTags = append(report.ImageTags, row)
+	}
+
+	for _, target := range slices.Sorted(maps.Keys(s.byTarget)) {
+		row := s.byTarget[target].row(target)
+		if seconds := report.Duration.Seconds(); seconds > 0 {
+			row.AchievedRPS = float64(row.Sent) / seconds
+		}
+		report.Targets = append(report.Targets, row)
+	}
+	return report
+}
+
+func (stats *summaryStats) row(name string) SummaryRow {
+	row := SummaryRow{
+		Name:      name,
+		Requests:  stats.requests,
+		Sent:      stats.sent,
+		Succeeded: stats.succeeded,
+		Dropped:   stats.dropped,
+	}
+	if stats.requests > 0 {
+		row.SuccessRate = float64(stats.succeeded) / float64(stats.requests)
+	}
+
+	row.Latency = summarizeHistogram(stats.latencies)
+
+	if len(stats.errors) > 0 {
+		row.Errors = make(map[string]int64, len(stats.errors))
+		for code, n := range stats.errors {
+			row.Errors[code.String()] = n
+		}
+	}
+
+	row.ColdStarts = int(stats.coldLatencies.TotalCount())
+	row.ColdStartLatency = summarizeHistogram(stats.coldLatencies).P50
+	return row
+}
+
+// status describes how the run ended.
+func (r SummaryReport) status() string {
+	switch {
+	case len(r.Failures) > 0:
+		return "failed"
+	case r.Interrupted:
+		return "interrupted"
+	default:
+		return "completed"
+	}
+}
+
+// WriteText prints the report as aligned tables.
+func (r SummaryReport) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Run %s after %v\n", r.status(), r.Duration.Round(time.Millisecond))
+	for _, failure := range r.Failures {
+		fmt.Fprintf(w, "Failure: %s\n", failure)
+	}
+	fmt.Fprintln(w)
+
+	for _, section := range r.sections("PHASE", "IMAGE TAG", "LEAF") {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tREQUESTS\tSUCCESS\tTARGET RPS\tACHIEVED RPS\tP50\tP90\tP95\tP99\tP99.9\tMAX\tCOLD STARTS\tERRORS\n", section.title)
+		for _, row := range section.rows {
+			fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%s\t%.1f\t%v\t%v\t%v\t%v\t%v\t%v\t%d\t%s\n",
+				row.Name, row.Requests, row.SuccessRate*100, formatTarget(row.TargetRPS), row.AchievedRPS,
+				row.Latency.P50, row.Latency.P90, row.Latency.P95, row.Latency.P99, row.Latency.P999, row.Latency.Max,
+				row.ColdStarts, formatErrorNames(row.Errors))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r SummaryReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteMarkdown writes the report as Markdown tables.
+func (r SummaryReport) WriteMarkdown(w io.Writer) error {
+	fmt.Fprintf(w, "# Load test summary\n\nRun %s after %v.\n", r.status(), r.Duration.Round(time.Millisecond))
+	if len(r.Failures) > 0 {
+		fmt.Fprintln(w)
+		for _, failure := range r.Failures {
+			fmt.Fprintf(w, "- %s\n", failure)
+		}
+	}
+
+	for _, section := range r.sections("Phase", "Image tag", "Leaf") {
+		fmt.Fprintf(w, "\n## By %s\n\n", strings.ToLower(section.title))
+		fmt.Fprintf(w, "| %s | Requests | Success | Target RPS | Achieved RPS | p50 | p90 | p95 | p99 | p99.9 | Max | Cold starts | Errors |\n", section.title)
+		fmt.Fprintln(w, "|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|---|")
+		for _, row := range section.rows {
+			fmt.Fprintf(w, "| %s | %d | %.2f%% | %s | %.1f | %v | %v | %v | %v | %v | %v | %d | %s |\n",
+				row.Name, row.Requests, row.SuccessRate*100, formatTarget(row.TargetRPS), row.AchievedRPS,
+				row.Latency.P50, row.Latency.P90, row.Latency.P95, row.Latency.P99, row.Latency.P999, row.Latency.Max,
+				row.ColdStarts, formatErrorNames(row.Errors))
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+type summarySection struct {
+	title string
+	rows  []SummaryRow
+}
+
+// sections lists the tables of the report. The Leaf table is only shown when the
+// calls were spread over several Leafs.
+func (r SummaryReport) sections(phase, imageTag, target string) []summarySection {
+	sections := []summarySection{{phase, r.Phases}, {imageTag, r.ImageTags}}
+	if len(r.Targets) > 1 {
+		sections = append(sections, summarySection{target, r.Targets})
+	}
+	return sections
+}
+
+func formatTarget(target *float64) string {
+	if target == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", *target)
+}
+
+func formatErrorNames(errors map[string]int64) string {
+	if len(errors) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(errors))
+	for _, name := range slices.Sorted(maps.Keys(errors)) {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, errors[name]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestSummary_Report(t *testing.T) {
+	s := NewSummary()
+	phases := []TestPhase{
+		{Name: "steady", Type: "constant", StartRPS: 10, Duration: 10 * time.Second, ImageTag: "echo:latest"},
+		{Name: "users", Type: "closed", StartUsers: 2, Duration: 10 * time.Second, ImageTag: "echo:latest"},
+	}
+	s.RunStarted(phases)
+	s.PhaseStarted(phases[0])
+	s.PhaseStarted(phases[1])
+
+	for i := 1; i <= 100; i++ {
+		s.Collect(CallResult{Phase: "steady", ImageTag: "echo:latest", InstanceID: "a", ColdStart: i == 1, Latency: time.Duration(i) * time.Millisecond, Outcome: OutcomeSent})
+	}
+	s.Collect(CallResult{Phase: "steady", ImageTag: "echo:latest", Status: codes.ResourceExhausted, Outcome: OutcomeDropped})
+	s.Collect(CallResult{Phase: "users", ImageTag: "echo:latest", InstanceID: "b", ColdStart: true, Latency: time.Second, Status: codes.Unavailable, Outcome: OutcomeSent})
+	s.Collect(CallResult{Outcome: OutcomeInterrupted})
+	s.PhaseFinished(phases[0])
+	s.PhaseFinished(phases[1])
+
+	report := s.Report()
+	if !report.Interrupted {
+		t.Errorf("Expected the report to be marked interrupted")
+	}
+	if len(report.Phases) != 2 || len(report.ImageTags) != 1 {
+		t.Fatalf("Expected 2 phase rows and 1 image tag row, got %d and %d", len(report.Phases), len(report.ImageTags))
+	}
+
+	steady := report.Phases[0]
+	if steady.Requests != 101 || steady.Sent != 100 || steady.Succeeded != 100 || steady.Dropped != 1 {
+		t.Errorf("Expected 101 requests, 100 sent and succeeded, 1 dropped, got %+v", steady)
+	}
+	// the histograms keep three significant digits
+	if !nearly(steady.Latency.P50, 50*time.Millisecond) || !nearly(steady.Latency.P99, 99*time.Millisecond) || !nearly(steady.Latency.Max, 100*time.Millisecond) {
+		t.Errorf("Expected p50 50ms, p99 99ms and max 100ms, got %+v", steady.Latency)
+	}
+	if steady.TargetRPS == nil || *steady.TargetRPS != 10 {
+		t.Errorf("Expected a target of 10 RPS, got %v", steady.TargetRPS)
+	}
+	if steady.Errors["ResourceExhausted"] != 1 {
+		t.Errorf("Expected 1 ResourceExhausted error, got %v", steady.Errors)
+	}
+	if steady.ColdStarts != 1 || !nearly(steady.ColdStartLatency, time.Millisecond) {
+		t.Errorf("Expected 1 cold start of 1ms, got %d of %v", steady.ColdStarts, steady.ColdStartLatency)
+	}
+
+	if users := report.Phases[1]; users.TargetRPS != nil || users.Errors["Unavailable"] != 1 {
+		t.Errorf("Expected no target and 1 Unavailable error for the closed phase, got %+v", users)
+	}
+
+	tag := report.ImageTags[0]
+	if tag.Requests != 102 || tag.ColdStarts != 2 || tag.TargetRPS != nil {
+		t.Errorf("Expected 102 requests, 2 cold starts and no target for the image tag, got %+v", tag)
+	}
+}
+
+func TestSummary_Targets(t *testing.T) {
+	s := NewSummary()
+	s.RunStarted(nil)
+	s.Collect(CallResult{Phase: "steady", Target: "leaf-a:50050", Latency: time.Millisecond, Outcome: OutcomeSent})
+	s.Collect(CallResult{Phase: "steady", Target: "leaf-b:50050", Latency: time.Millisecond, Status: codes.Unavailable, Outcome: OutcomeSent})
+	s.Collect(CallResult{Phase: "steady", Target: "leaf-b:50050", Latency: time.Millisecond, Outcome: OutcomeSent})
+	s.Collect(CallResult{Phase: "steady", Status: codes.ResourceExhausted, Outcome: OutcomeDropped})
+
+	report := s.Report()
+	if len(report.Targets) != 2 {
+		t.Fatalf("Expected 2 Leaf rows without the dropped call, got %+v", report.Targets)
+	}
+	if b := report.Targets[1]; b.Name != "leaf-b:50050" || b.Requests != 2 || b.Succeeded != 1 || b.Errors["Unavailable"] != 1 {
+		t.Errorf("Expected 2 requests, 1 success and 1 Unavailable error on leaf-b, got %+v", b)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "LEAF") {
+		t.Errorf("Expected a Leaf table, got:\n%s", text.String())
+	}
+}
+
+func TestSummaryReport_Write(t *testing.T) {
+	target := 10.0
+	report := SummaryReport{
+		Duration: time.Minute,
+		Phases:   []SummaryRow{{Name: "steady", Requests: 10, Succeeded: 9, SuccessRate: 0.9, TargetRPS: &target, Errors: map[string]int64{"Unavailable": 1}}},
+	}
+
+	var text, md, js bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, out := range []string{text.String(), md.String()} {
+		for _, expected := range []string{"steady", "90.00%", "10.0", "Unavailable=1"} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("Expected output to contain %q:\n%s", expected, out)
+			}
+		}
+	}
+
+	var decoded SummaryReport
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if decoded.Duration != time.Minute || *decoded.Phases[0].TargetRPS != 10 {
+		t.Errorf("Expected the report to round-trip, got %+v", decoded)
+	}
+}
+
+func TestExpectedRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		phase    TestPhase
+		expected float64
+		ok       bool
+	}{
+		{"constant", TestPhase{Type: "constant", StartRPS: 10, Duration: 10 * time.Second}, 100, true},
+		{"variable", TestPhase{Type: "variable", StartRPS: 10, EndRPS: 30, Step: 10, Duration: 4 * time.Second}, 90, true},
+		{"closed", TestPhase{Type: "closed", StartUsers: 1, Duration: time.Second}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, ok := ExpectedRequests(tt.phase)
+			if ok != tt.ok || expected < tt.expected-0.001 || expected > tt.expected+0.001 {
+				t.Errorf("ExpectedRequests() = (%v, %v), expected (%v, %v)", expected, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+// nearly tells whether a latency read from a histogram is within its precision of want.
+func nearly(got, want time.Duration) bool {
+	return got >= want-want/1000-time.Microsecond && got <= want+want/1000+time.Microsecond
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"slices"
+	"time"
+)
+
+// targetedPhaseTypes are the phase types with a rate target.
+var targetedPhaseTypes = []string{"constant", "poisson", "variable", "spike", "mmpp"}
+
+// TargetRPS returns the rate a phase aims for at elapsed since its start. It
+// returns false for phases without a rate target (trace replays, closed loops).
+// For mmpp phases it is the long-run mean rate.
+func TargetRPS(phase TestPhase, elapsed time.Duration) (float64, bool) {
+	if !slices.Contains(targetedPhaseTypes, phase.Type) {
+		return 0, false
+	}
+	if elapsed < 0 || elapsed >= phase.Duration {
+		return 0, true
+	}
+
+	switch phase.Type {
+	case "constant", "poisson":
+		return float64(phase.StartRPS), true
+	case "variable":
+		start := phase.StartRPS
+		if start == 0 {
+			start = 1
+		}
+		return float64(max(phase.ramp(start, phase.EndRPS, int(elapsed/time.Second)), 0)), true
+	case "spike":
+		if spiking, _ := spikeAt(phase.BurstInterval, phase.BurstDuration, elapsed); spiking {
+			return float64(phase.BurstRPS), true
+		}
+		return float64(phase.StartRPS), true
+	default: // mmpp
+		total := phase.IdleDuration + phase.BurstDuration
+		if total <= 0 {
+			return float64(phase.StartRPS), true
+		}
+		return (float64(phase.StartRPS)*float64(phase.IdleDuration) + float64(phase.BurstRPS)*float64(phase.BurstDuration)) / float64(total), true
+	}
+}
+
+// ExpectedRequests returns how many requests a phase should send over its whole
+// duration, and false if it has no rate target.
+func ExpectedRequests(phase TestPhase) (float64, bool) {
+	return expectedRequests(phase, phase.Duration)
+}
+
+// expectedRequests is ExpectedRequests for the first until of the phase.
+func expectedRequests(phase TestPhase, until time.Duration) (float64, bool) {
+	const step = 100 * time.Millisecond
+	if _, ok := TargetRPS(phase, 0); !ok {
+		return 0, false
+	}
+	until = min(until, phase.Duration)
+	var total float64
+	for elapsed := time.Duration(0); elapsed < until; elapsed += step {
+		rps, _ := TargetRPS(phase, elapsed)
+		total += rps * min(step, until-elapsed).Seconds()
+	}
+	return total, true
+}
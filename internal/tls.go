@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLSConfig secures the connections to the Leafs. Setting the section enables
+// TLS; the certificate and key enable mTLS.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`              // PEM CA that signed the Leaf certificates, the system roots if unset
+	CertFile           string `yaml:"cert_file,omitempty"`            // PEM client certificate for mTLS
+	KeyFile            string `yaml:"key_file,omitempty"`             // PEM key of the client certificate
+	ServerName         string `yaml:"server_name,omitempty"`          // name checked against the Leaf certificate instead of the address
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"` // accept any Leaf certificate, for testing only
+}
+
+func (t *TLSConfig) validate() error {
+	if t == nil {
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls: cert_file and key_file must be set together")
+	}
+	return nil
+}
+
+// transportCredentials loads the certificates. Without a TLS section the
+// connections are insecure.
+func (t *TLSConfig) transportCredentials() (credentials.TransportCredentials, error) {
+	if t == nil {
+		return insecure.NewCredentials(), nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
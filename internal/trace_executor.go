@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TraceExecutor replays an Azure Functions style invocation trace. Two layouts are
+// detected from the CSV header:
+//   - per minute (2019): HashOwner,HashApp,HashFunction,Trigger,1,2,...,1440
+//   - per invocation (2021): app,func,end_timestamp,duration
+//
+// The invocations of all functions selected by TraceFunctions are merged and sent
+// to the phase's image tag; hashes are mapped onto several image tags with one
+// phase per tag.
+type TraceExecutor struct {
+	stopper
+	client       Client
+	collector    DataCollector
+	funcMgr      *FunctionManager
+	l            *slog.Logger
+	dataProvider DataProvider
+	limiter      *InFlightLimiter
+}
+
+func NewTraceExecutor(client Client, collector DataCollector, funcMgr *FunctionManager, l *slog.Logger, dataProvider DataProvider, limiter *InFlightLimiter) *TraceExecutor {
+	return &TraceExecutor{
+		stopper:      newStopper(),
+		client:       client,
+		collector:    collector,
+		funcMgr:      funcMgr,
+		l:            l,
+		dataProvider: dataProvider,
+		limiter:      limiter,
+	}
+}
+
+func (e *TraceExecutor) Execute(ctx context.Context, phase TestPhase) error {
+	trace := phase.trace
+	if trace == nil {
+		var err error
+		if trace, err = loadTrace(phase.TraceFile, phase.TraceFunctions); err != nil {
+			return fmt.Errorf("failed to load trace %s: %w", phase.TraceFile, err)
+		}
+	}
+
+	timeScale := phase.TimeScale
+	if timeScale == 0 {
+		timeScale = 1
+	}
+	rpsScale := phase.RPSScale
+	if rpsScale == 0 {
+		rpsScale = 1
+	}
+
+	ctx, runCtx, finish := e.begin(ctx, phase.DrainTimeout)
+	subCtx, cancel := context.WithTimeout(runCtx, phase.Duration)
+	defer cancel()
+
+	fire := func(intended time.Time) {
+		dispatchCall(ctx, subCtx, &e.calls, e.limiter, e.client, e.collector, e.dataProvider, phase, intended)
+	}
+
+	seed := uint64(time.Now().UnixNano())
+	scheduler := NewArrivalScheduler(time.Now(), phase.Arrival, seed)
+
+	if trace.perMinute != nil {
+		e.l.Debug("Trace executor", "Phase", phase.Name, "Minutes", len(trace.perMinute), "Time scale", timeScale, "RPS scale", rpsScale)
+		minute := time.Duration(float64(time.Minute) / timeScale)
+		scheduler.RunPiecewise(subCtx, func(elapsed time.Duration) (float64, time.Duration) {
+			i := int(elapsed / minute)
+			if i >= len(trace.perMinute) {
+				return 0, math.MaxInt64
+			}
+			return trace.perMinute[i] * rpsScale / minute.Seconds(), time.Duration(i+1) * minute
+		}, fire)
+		return finish()
+	}
+
+	offsets := scaleInvocations(trace.invocations, timeScale, rpsScale, rand.New(rand.NewPCG(seed, seed)))
+	e.l.Debug("Trace executor", "Phase", phase.Name, "Invocations", len(offsets), "Time scale", timeScale, "RPS scale", rpsScale)
+	scheduler.RunAt(subCtx, offsets, fire)
+	return finish()
+}
+
+type invocationTrace struct {
+	// perMinute holds the summed invocation counts of the selected functions for each trace minute
+	perMinute []float64
+	// invocations holds the sorted start offsets of individual invocations, relative to the first one
+	invocations []time.Duration
+}
+
+// empty reports whether the trace has no invocation to replay.
+func (t *invocationTrace) empty() bool {
+	return len(t.invocations) == 0 && !slices.ContainsFunc(t.perMinute, func(count float64) bool { return count > 0 })
+}
+
+func loadTrace(path string, functions []string) (*invocationTrace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseTrace(f, functions)
+}
+
+func parseTrace(r io.Reader, functions []string) (*invocationTrace, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	matched := make(map[string]bool, len(functions))
+	selected := func(hash string) bool {
+		if len(functions) == 0 {
+			return true
+		}
+		if slices.Contains(functions, hash) {
+			matched[hash] = true
+			return true
+		}
+		return false
+	}
+	// a misspelled hash or the wrong trace file would replay nothing
+	checkMatched := func() error {
+		var missing []string
+		for _, hash := range functions {
+			if !matched[hash] {
+				missing = append(missing, hash)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("trace has no function %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+
+	trace := &invocationTrace{}
+	if hashCol, ok := columns["HashFunction"]; ok {
+		first, ok := columns["1"]
+		if !ok {
+			return nil, errors.New("per minute trace has no minute columns")
+		}
+		minutes := len(header) - first
+		trace.perMinute = make([]float64, minutes)
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !selected(record[hashCol]) {
+				continue
+			}
+			for i := 0; i < minutes; i++ {
+				count, err := strconv.ParseFloat(record[first+i], 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid count for function %s in minute %d: %w", record[hashCol], i+1, err)
+				}
+				trace.perMinute[i] += count
+			}
+		}
+		if err := checkMatched(); err != nil {
+			return nil, err
+		}
+		return trace, nil
+	}
+
+	funcCol, okFunc := columns["func"]
+	endCol, okEnd := columns["end_timestamp"]
+	durationCol, okDuration := columns["duration"]
+	if !okFunc || !okEnd || !okDuration {
+		return nil, errors.New("unsupported trace format (expected HashFunction and minute columns, or func, end_timestamp and duration)")
+	}
+
+	var starts []float64
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !selected(record[funcCol]) {
+			continue
+		}
+		end, err := strconv.ParseFloat(record[endCol], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end_timestamp %q: %w", record[endCol], err)
+		}
+		duration, err := strconv.ParseFloat(record[durationCol], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q: %w", record[durationCol], err)
+		}
+		starts = append(starts, end-duration)
+	}
+	if err := checkMatched(); err != nil {
+		return nil, err
+	}
+
+	slices.Sort(starts)
+	trace.invocations = make([]time.Duration, len(starts))
+	for i, start := range starts {
+		trace.invocations[i] = time.Duration((start - starts[0]) * float64(time.Second))
+	}
+	return trace, nil
+}
+
+// scaleInvocations compresses the invocation offsets by timeScale and thins or
+// multiplies them by rpsScale. Fractional scales keep each (extra) invocation
+// with the remaining probability.
+func scaleInvocations(invocations []time.Duration, timeScale, rpsScale float64, random *rand.Rand) []time.Duration {
+	whole := int(rpsScale)
+	fraction := rpsScale - float64(whole)
+
+	scaled := make([]time.Duration, 0, int(float64(len(invocations))*rpsScale)+1)
+	for _, offset := range invocations {
+		copies := whole
+		if fraction > 0 && random.Float64() < fraction {
+			copies++
+		}
+		at := time.Duration(float64(offset) / timeScale)
+		for i := 0; i < copies; i++ {
+			scaled = append(scaled, at)
+		}
+	}
+	return scaled
+}
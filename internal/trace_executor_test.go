@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTrace_PerMinute(t *testing.T) {
+	csv := `HashOwner,HashApp,HashFunction,Trigger,1,2,3
+o1,a1,f1,http,10,0,5
+o1,a1,f2,timer,1,1,1
+o2,a2,f3,queue,100,200,300
+`
+	tests := []struct {
+		name      string
+		functions []string
+		expected  []float64
+	}{
+		{"all_functions", nil, []float64{111, 201, 306}},
+		{"selected_functions", []string{"f1", "f2"}, []float64{11, 1, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := parseTrace(strings.NewReader(csv), tt.functions)
+			if err != nil {
+				t.Fatalf("parseTrace() returned error: %v", err)
+			}
+			if trace.invocations != nil {
+				t.Errorf("Expected no individual invocations for a per minute trace")
+			}
+			if len(trace.perMinute) != len(tt.expected) {
+				t.Fatalf("Expected %d minutes, got %d", len(tt.expected), len(trace.perMinute))
+			}
+			for i := range tt.expected {
+				if trace.perMinute[i] != tt.expected[i] {
+					t.Errorf("Minute %d: expected %v invocations, got %v", i+1, tt.expected[i], trace.perMinute[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseTrace_PerInvocation(t *testing.T) {
+	csv := `app,func,end_timestamp,duration
+a1,f1,12.5,2.5
+a1,f2,11.0,0.5
+a1,f1,10.25,0.25
+`
+	trace, err := parseTrace(strings.NewReader(csv), []string{"f1"})
+	if err != nil {
+		t.Fatalf("parseTrace() returned error: %v", err)
+	}
+	// both f1 invocations started at 10.0
+	expected := []time.Duration{0, 0}
+	if len(trace.invocations) != len(expected) || trace.invocations[1] != 0 {
+		t.Fatalf("Expected invocations %v, got %v", expected, trace.invocations)
+	}
+
+	trace, err = parseTrace(strings.NewReader(csv), nil)
+	if err != nil {
+		t.Fatalf("parseTrace() returned error: %v", err)
+	}
+	expected = []time.Duration{0, 0, 500 * time.Millisecond}
+	if len(trace.invocations) != len(expected) {
+		t.Fatalf("Expected %d invocations, got %v", len(expected), trace.invocations)
+	}
+	for i := range expected {
+		if trace.invocations[i] != expected[i] {
+			t.Errorf("Invocation %d: expected offset %v, got %v", i, expected[i], trace.invocations[i])
+		}
+	}
+}
+
+func TestParseTrace_MissingFunction(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"per_minute", "HashOwner,HashApp,HashFunction,Trigger,1,2\no1,a1,f1,http,10,0\n"},
+		{"per_invocation", "app,func,end_timestamp,duration\na1,f1,12.5,2.5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTrace(strings.NewReader(tt.csv), []string{"f1", "typo", "f9"})
+			if err == nil || !strings.Contains(err.Error(), "typo, f9") {
+				t.Errorf("Expected an error naming the missing functions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInvocationTrace_Empty(t *testing.T) {
+	trace, err := parseTrace(strings.NewReader("HashOwner,HashApp,HashFunction,Trigger,1,2\no1,a1,f1,http,0,0\no1,a1,f2,http,0,3\n"), []string{"f1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trace.empty() {
+		t.Errorf("Expected a trace without invocations to be empty, got %v", trace.perMinute)
+	}
+	if (&invocationTrace{perMinute: []float64{0, 3}}).empty() || (&invocationTrace{invocations: []time.Duration{0}}).empty() {
+		t.Errorf("Expected traces with invocations not to be empty")
+	}
+}
+
+func TestParseTrace_UnknownFormat(t *testing.T) {
+	if _, err := parseTrace(strings.NewReader("a,b,c\n1,2,3\n"), nil); err == nil {
+		t.Error("Expected an error for an unknown trace format")
+	}
+}
+
+func TestScaleInvocations(t *testing.T) {
+	invocations := []time.Duration{0, time.Minute, 2 * time.Minute}
+
+	scaled := scaleInvocations(invocations, 60, 2, rand.New(rand.NewPCG(1, 1)))
+	expected := []time.Duration{0, 0, time.Second, time.Second, 2 * time.Second, 2 * time.Second}
+	if len(scaled) != len(expected) {
+		t.Fatalf("Expected %d invocations, got %v", len(expected), scaled)
+	}
+	for i := range expected {
+		if scaled[i] != expected[i] {
+			t.Errorf("Invocation %d: expected %v, got %v", i, expected[i], scaled[i])
+		}
+	}
+
+	many := make([]time.Duration, 10000)
+	thinned := scaleInvocations(many, 1, 0.25, rand.New(rand.NewPCG(1, 1)))
+	if len(thinned) < 2200 || len(thinned) > 2800 {
+		t.Errorf("Expected roughly 2500 invocations at rps scale 0.25, got %d", len(thinned))
+	}
+}
+
+func TestWithConfigFile_LoadsTrace(t *testing.T) {
+	dir := t.TempDir()
+	tracePath := filepath.Join(dir, "trace.csv")
+	if err := os.WriteFile(tracePath, []byte("HashOwner,HashApp,HashFunction,Trigger,1,2\no1,a1,f1,http,60,120\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	config := `
+leaf_address: localhost:50050
+max_duration: 10s
+timeout: 10
+workload:
+  phases:
+    - {name: replay, type: trace, duration: 2s, image_tag: "hyperfaas-echo:latest", trace_file: "` + tracePath + `", time_scale: 60}
+`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{}
+	WithConfigFile(configPath)(c)
+
+	trace := c.Config.Workload.Phases[0].trace
+	if trace == nil || len(trace.perMinute) != 2 || trace.perMinute[1] != 120 {
+		t.Errorf("Expected the trace to be loaded with the config, got %+v", trace)
+	}
+}
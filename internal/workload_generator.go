@@ -20,6 +20,9 @@ type PhasePattern struct {
 	PhaseCount         IntRange        `yaml:"phase_count"`
 	ConstantLikelihood float64         `yaml:"constant_likelihood"` // 0.0-1.0
 	RampingLikelihood  float64         `yaml:"ramping_likelihood"`  // 0.0-1.0
+	PoissonLikelihood  float64         `yaml:"poisson_likelihood"`  // 0.0-1.0
+	MMPPLikelihood     float64         `yaml:"mmpp_likelihood"`     // 0.0-1.0
+	SpikeLikelihood    float64         `yaml:"spike_likelihood"`    // 0.0-1.0
 	Parameters         PhaseParameters `yaml:"parameters"`
 }
 
@@ -27,6 +30,11 @@ type PhaseParameters struct {
 	StartRPS IntRange `yaml:"start_rps"`
 	EndRPS   IntRange `yaml:"end_rps"`
 	Step     IntRange `yaml:"step"`
+	// only drawn for mmpp and spike phases
+	BurstRPS      IntRange      `yaml:"burst_rps"`
+	BurstDuration DurationRange `yaml:"burst_duration"`
+	IdleDuration  DurationRange `yaml:"idle_duration"`
+	BurstInterval DurationRange `yaml:"burst_interval"`
 }
 
 type IntRange struct {
@@ -34,6 +42,11 @@ type IntRange struct {
 	Max int `yaml:"max"`
 }
 
+type DurationRange struct {
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+}
+
 func NewWorkloadGenerator(seed int64, maxDuration time.Duration, leafAddress string, timeout int32, patterns map[string]*PhasePattern) *WorkloadGenerator {
 	return &WorkloadGenerator{
 		seed:        seed,
@@ -66,13 +79,7 @@ func (g *WorkloadGenerator) GenerateWorkload() *Workload {
 		for i := 0; i < phaseCount; i++ {
 			phaseStartTime := times[pattern.ImageTag]
 			times[pattern.ImageTag] += phaseDuration
-			c := g.random.Float64()
-			var phaseType string
-			if c < pattern.ConstantLikelihood {
-				phaseType = "constant"
-			} else {
-				phaseType = "variable"
-			}
+			phaseType := g.pickPhaseType(pattern)
 
 			phase := TestPhase{
 				Name:      pattern.ImageTag + "_" + strconv.Itoa(i),
@@ -84,6 +91,16 @@ func (g *WorkloadGenerator) GenerateWorkload() *Workload {
 				EndRPS:    g.getRandInt(pattern.Parameters.EndRPS.Min, pattern.Parameters.EndRPS.Max),
 				Step:      g.getRandInt(pattern.Parameters.Step.Min, pattern.Parameters.Step.Max),
 			}
+			switch phaseType {
+			case "mmpp":
+				phase.BurstRPS = g.getRandInt(pattern.Parameters.BurstRPS.Min, pattern.Parameters.BurstRPS.Max)
+				phase.BurstDuration = g.getRandDuration(pattern.Parameters.BurstDuration.Min, pattern.Parameters.BurstDuration.Max)
+				phase.IdleDuration = g.getRandDuration(pattern.Parameters.IdleDuration.Min, pattern.Parameters.IdleDuration.Max)
+			case "spike":
+				phase.BurstRPS = g.getRandInt(pattern.Parameters.BurstRPS.Min, pattern.Parameters.BurstRPS.Max)
+				phase.BurstDuration = g.getRandDuration(pattern.Parameters.BurstDuration.Min, pattern.Parameters.BurstDuration.Max)
+				phase.BurstInterval = g.getRandDuration(pattern.Parameters.BurstInterval.Min, pattern.Parameters.BurstInterval.Max)
+			}
 			workload.Phases = append(workload.Phases, phase)
 		}
 	}
@@ -91,6 +108,34 @@ func (g *WorkloadGenerator) GenerateWorkload() *Workload {
 	return workload
 }
 
+// pickPhaseType draws a phase type from the pattern likelihoods. Whatever is not
+// covered by the other likelihoods falls through to a ramping phase.
+func (g *WorkloadGenerator) pickPhaseType(pattern *PhasePattern) string {
+	c := g.random.Float64()
+	for _, t := range []struct {
+		phaseType  string
+		likelihood float64
+	}{
+		{"constant", pattern.ConstantLikelihood},
+		{"poisson", pattern.PoissonLikelihood},
+		{"mmpp", pattern.MMPPLikelihood},
+		{"spike", pattern.SpikeLikelihood},
+	} {
+		if c < t.likelihood {
+			return t.phaseType
+		}
+		c -= t.likelihood
+	}
+	return "variable"
+}
+
+func (g *WorkloadGenerator) getRandDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(g.random.Int64N(int64(max-min)+1))
+}
+
 func (g *WorkloadGenerator) getRandInt(min, max int) int {
 	return g.random.IntN(max-min+1) + min
 }
@@ -137,6 +137,33 @@ func TestWorkloadGenerator_GenerateWorkload(t *testing.T) {
 			},
 			validate: validateSingleValueRanges,
 		},
+		{
+			name:        "bursty_phase_types",
+			seed:        5,
+			maxDuration: 60 * time.Second,
+			patterns: map[string]*PhasePattern{
+				"bursty:test": {
+					ImageTag: "bursty:test",
+					PhaseCount: IntRange{
+						Min: 20,
+						Max: 20,
+					},
+					PoissonLikelihood: 0.3,
+					MMPPLikelihood:    0.3,
+					SpikeLikelihood:   0.4,
+					Parameters: PhaseParameters{
+						StartRPS:      IntRange{Min: 5, Max: 10},
+						EndRPS:        IntRange{Min: 20, Max: 30},
+						Step:          IntRange{Min: 1, Max: 2},
+						BurstRPS:      IntRange{Min: 100, Max: 200},
+						BurstDuration: DurationRange{Min: time.Second, Max: 2 * time.Second},
+						IdleDuration:  DurationRange{Min: 5 * time.Second, Max: 10 * time.Second},
+						BurstInterval: DurationRange{Min: 10 * time.Second, Max: 15 * time.Second},
+					},
+				},
+			},
+			validate: validateBurstyPhases,
+		},
 	}
 
 	for _, tt := range tests {
@@ -331,3 +358,41 @@ func getPhasesByImageTag(phases []TestPhase, imageTag string) []TestPhase {
 	}
 	return result
 }
+
+func validateBurstyPhases(t *testing.T, workload *Workload, patterns map[string]*PhasePattern, maxDuration time.Duration) {
+	params := patterns["bursty:test"].Parameters
+	types := make(map[string]int)
+
+	for i, phase := range workload.Phases {
+		types[phase.Type]++
+		validateParameterRanges(t, phase, params)
+
+		switch phase.Type {
+		case "poisson":
+			if phase.BurstRPS != 0 {
+				t.Errorf("Phase %d: Expected no burst parameters for poisson phase", i)
+			}
+		case "mmpp", "spike":
+			if phase.BurstRPS < params.BurstRPS.Min || phase.BurstRPS > params.BurstRPS.Max {
+				t.Errorf("Phase %d: BurstRPS %d is outside expected range", i, phase.BurstRPS)
+			}
+			if phase.BurstDuration < params.BurstDuration.Min || phase.BurstDuration > params.BurstDuration.Max {
+				t.Errorf("Phase %d: BurstDuration %v is outside expected range", i, phase.BurstDuration)
+			}
+			if phase.Type == "mmpp" && (phase.IdleDuration < params.IdleDuration.Min || phase.IdleDuration > params.IdleDuration.Max) {
+				t.Errorf("Phase %d: IdleDuration %v is outside expected range", i, phase.IdleDuration)
+			}
+			if phase.Type == "spike" && (phase.BurstInterval < params.BurstInterval.Min || phase.BurstInterval > params.BurstInterval.Max) {
+				t.Errorf("Phase %d: BurstInterval %v is outside expected range", i, phase.BurstInterval)
+			}
+		default:
+			t.Errorf("Phase %d: Expected a poisson, mmpp or spike phase, got %s", i, phase.Type)
+		}
+	}
+
+	for _, phaseType := range []string{"poisson", "mmpp", "spike"} {
+		if types[phaseType] == 0 {
+			t.Errorf("Expected at least one %s phase out of %d", phaseType, len(workload.Phases))
+		}
+	}
+}